@@ -12,14 +12,12 @@ import (
 	"github.com/benhoyt/goawk/parser"
 )
 
-// For script execution
-var mu sync.Mutex
-
 // Command a container for awk script execution
 type Command struct {
 	Parser      *parser.Program
 	Config      *interp.Config
 	Interpreter *interp.Interpreter
+	mu          sync.Mutex // the interpreter can only run one payload at a time
 }
 
 // NewCommand make a new Awk struct for running awk scripts
@@ -40,10 +38,11 @@ func NewCommand(command string) (awk *Command, err error) {
 }
 
 // Execute run a precompiled interpreter against a payload
-func (cmd *Command) Execute(payload string) (output string, err error) {
+// Optional name and value pairs are set as awk variables.
+func (cmd *Command) Execute(payload string, vars ...string) (output string, err error) {
 	// mutex is needed to avoid errors from asynchronous use of map
-	mu.Lock()
-	defer mu.Unlock()
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
 
 	outBuf := new(bytes.Buffer)
 	errBuf := new(bytes.Buffer)
@@ -52,6 +51,7 @@ func (cmd *Command) Execute(payload string) (output string, err error) {
 		Output: outBuf,
 		Stdin:  strings.NewReader(payload),
 		Error:  errBuf,
+		Vars:   vars,
 	}
 
 	result, err := cmd.Interpreter.Execute(config)
@@ -63,3 +63,42 @@ func (cmd *Command) Execute(payload string) (output string, err error) {
 
 	return
 }
+
+// Expressions awk expressions compiled once and kept for reuse
+type Expressions struct {
+	mu       sync.Mutex
+	programs map[string]*Command
+}
+
+// NewExpressions make a new cache of compiled awk expressions
+func NewExpressions() *Expressions {
+	return &Expressions{programs: make(map[string]*Command)}
+}
+
+// Evaluate evaluate an awk expression against an input line and get its value
+// Optional name and value pairs are set as awk variables. Without a cache the expression is compiled each time.
+func (e *Expressions) Evaluate(expression, line string, vars ...string) (value string, err error) {
+	var program *Command
+	if e != nil {
+		e.mu.Lock()
+		program = e.programs[expression]
+		e.mu.Unlock()
+	}
+	if program == nil {
+		program, err = NewCommand(fmt.Sprintf("{ print (%s) }", expression))
+		if err != nil {
+			err = fmt.Errorf("awk expression %s: %v", expression, err)
+			return
+		}
+		if e != nil {
+			e.mu.Lock()
+			e.programs[expression] = program
+			e.mu.Unlock()
+		}
+	}
+
+	value, err = program.Execute(line+"\n", vars...)
+	value = strings.TrimSuffix(value, "\n")
+
+	return
+}
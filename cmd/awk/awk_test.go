@@ -49,6 +49,21 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+func TestExpressions(t *testing.T) {
+	is := is.New(t)
+
+	expressions := NewExpressions()
+	for _, c := range []struct{ line, n, value string }{{"a 1", "0", "a10-0"}, {"b 2", "1", "b20-1"}} {
+		value, err := expressions.Evaluate(`$1 ($2 * 10) "-" n`, c.line, "n", c.n)
+		is.NoErr(err)
+		is.Equal(value, c.value)
+	}
+	is.Equal(len(expressions.programs), 1)
+
+	_, err := expressions.Evaluate("(", "a")
+	is.True(err != nil)
+}
+
 // go test -bench=. -benchmem
 func BenchmarkFib10(b *testing.B) {
 	is := is.New(b)
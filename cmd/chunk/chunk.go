@@ -0,0 +1,173 @@
+// Split input into chunks on record boundaries so each chunk can be piped to a job
+
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultBlock size of a chunk if no other size is set
+const DefaultBlock = 1 << 20
+
+// readSize most bytes read from the input at a time
+const readSize = 64 << 10
+
+// Reader read chunks of whole records from an input
+// A record ends with RecEnd and the next record starts with RecStart. A chunk is at least Block bytes, extended to the
+// end of the record the block ends in, unless Lines is set, in which case each chunk has that many records.
+type Reader struct {
+	Block    int
+	Lines    int
+	RecEnd   []byte
+	RecStart []byte
+	r        io.Reader
+	buf      []byte
+	eof      bool
+}
+
+// NewReader make a new chunk reader with a block size. Records end with a newline.
+func NewReader(r io.Reader, block int) *Reader {
+	if block < 1 {
+		block = DefaultBlock
+	}
+
+	return &Reader{
+		Block:  block,
+		RecEnd: []byte("\n"),
+		r:      r,
+	}
+}
+
+// Next get the next chunk. io.EOF is returned once there are no more chunks.
+func (cr *Reader) Next() (chunk []byte, err error) {
+	if len(cr.RecEnd) == 0 && len(cr.RecStart) == 0 {
+		return nil, fmt.Errorf("a record end or record start is needed to split input")
+	}
+
+	// searched is where to resume looking for a boundary after more has been read
+	var searched int
+	var count int
+	for {
+		var split int
+		if cr.Lines > 0 {
+			split, searched, count = cr.records(searched, count)
+		} else {
+			from := cr.Block
+			if searched > from {
+				from = searched
+			}
+			split = cr.boundary(from)
+			if end := len(cr.buf) - len(cr.RecEnd) - len(cr.RecStart); end > searched {
+				searched = end
+			}
+		}
+		if split > 0 {
+			chunk = cr.buf[:split:split]
+			cr.buf = cr.buf[split:]
+			return
+		}
+		if cr.eof {
+			if len(cr.buf) == 0 {
+				return nil, io.EOF
+			}
+			chunk = cr.buf
+			cr.buf = nil
+			return
+		}
+		err = cr.fill()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// records find the end of the chunk after Lines records, starting from a count already found
+func (cr *Reader) records(from, count int) (split, searched, found int) {
+	for count < cr.Lines {
+		p := cr.boundary(from + 1)
+		if p < 0 {
+			return -1, from, count
+		}
+		from = p
+		count++
+	}
+
+	return from, from, count
+}
+
+// boundary find the first record boundary at or after an offset in the buffer, or -1 if there isn't one yet
+func (cr *Reader) boundary(from int) int {
+	if from < 1 {
+		from = 1
+	}
+	switch {
+	case len(cr.RecEnd) > 0:
+		sep := append(append([]byte{}, cr.RecEnd...), cr.RecStart...)
+		start := from - len(cr.RecEnd)
+		if start < 0 {
+			start = 0
+		}
+		if start >= len(cr.buf) {
+			return -1
+		}
+		i := bytes.Index(cr.buf[start:], sep)
+		if i < 0 {
+			return -1
+		}
+		return start + i + len(cr.RecEnd)
+	default:
+		if from >= len(cr.buf) {
+			return -1
+		}
+		i := bytes.Index(cr.buf[from:], cr.RecStart)
+		if i < 0 {
+			return -1
+		}
+		return from + i
+	}
+}
+
+// fill read more of the input into the buffer
+// Only what is available is read so records from a slow input are sent as they arrive.
+func (cr *Reader) fill() (err error) {
+	buf := make([]byte, readSize)
+	n, err := cr.r.Read(buf)
+	cr.buf = append(cr.buf, buf[:n]...)
+	if err == io.EOF {
+		cr.eof = true
+		err = nil
+	}
+
+	return
+}
+
+// Size get a size in bytes from a value such as 512k or 10M
+func Size(value string) (size int, err error) {
+	number := strings.TrimSpace(value)
+	multiplier := 1
+	if number != "" {
+		switch number[len(number)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+	size, err = strconv.Atoi(number)
+	if err != nil || size < 1 {
+		err = fmt.Errorf("invalid size %s", value)
+		return
+	}
+	size *= multiplier
+
+	return
+}
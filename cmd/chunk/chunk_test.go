@@ -0,0 +1,107 @@
+package chunk
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// chunks read all chunks as strings
+func chunks(t *testing.T, cr *Reader) (all []string) {
+	for {
+		chunk, err := cr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, string(chunk))
+	}
+}
+
+// shortReader an input that gives what has been sent to it one send at a time, as a pipe from a slow program does
+type shortReader struct {
+	reads chan string
+}
+
+// Read wait for the next send
+func (r shortReader) Read(p []byte) (int, error) {
+	s, ok := <-r.reads
+	if !ok {
+		return 0, io.EOF
+	}
+
+	return copy(p, s), nil
+}
+
+func TestReader(t *testing.T) {
+	is := is.New(t)
+
+	// A block is extended to the end of its record
+	cr := NewReader(strings.NewReader("aaa\nbb\ncccc\nd"), 5)
+	is.Equal(chunks(t, cr), []string{"aaa\nbb\n", "cccc\n", "d"})
+
+	// Each chunk has a number of lines
+	cr = NewReader(strings.NewReader("1\n2\n3\n4\n5\n"), 0)
+	cr.Lines = 2
+	is.Equal(chunks(t, cr), []string{"1\n2\n", "3\n4\n", "5\n"})
+
+	// Records that start with a marker
+	cr = NewReader(strings.NewReader(">a\nx\n>b\ny\n>c\n"), 1)
+	cr.RecEnd = []byte("\n")
+	cr.RecStart = []byte(">")
+	is.Equal(chunks(t, cr), []string{">a\nx\n", ">b\ny\n", ">c\n"})
+
+	cr = NewReader(strings.NewReader(">a\nx\n>b\ny\n>c\n"), 1)
+	cr.RecEnd = nil
+	cr.RecStart = []byte(">")
+	cr.Lines = 2
+	is.Equal(chunks(t, cr), []string{">a\nx\n>b\ny\n", ">c\n"})
+
+	// Input larger than a read
+	long := strings.Repeat("0123456789\n", 20000)
+	cr = NewReader(strings.NewReader(long), 100000)
+	all := chunks(t, cr)
+	is.Equal(len(all), 3)
+	is.Equal(strings.Join(all, ""), long)
+
+	// Records are sent as they arrive without waiting for more input
+	reads := make(chan string, 1)
+	cr = NewReader(shortReader{reads}, 0)
+	cr.Lines = 1
+	reads <- "1\n2"
+	next := make(chan string)
+	go func() {
+		chunk, _ := cr.Next()
+		next <- string(chunk)
+	}()
+	select {
+	case chunk := <-next:
+		is.Equal(chunk, "1\n")
+	case <-time.After(time.Second):
+		t.Fatal("waited for more input to send a record")
+	}
+	reads <- "\n3\n"
+	close(reads)
+	is.Equal(chunks(t, cr), []string{"2\n", "3\n"})
+}
+
+func TestSize(t *testing.T) {
+	is := is.New(t)
+
+	size, err := Size("10")
+	is.NoErr(err)
+	is.Equal(size, 10)
+	size, err = Size("2k")
+	is.NoErr(err)
+	is.Equal(size, 2048)
+	size, err = Size("1M")
+	is.NoErr(err)
+	is.Equal(size, 1<<20)
+	_, err = Size("x")
+	is.True(err != nil)
+}
@@ -2,49 +2,54 @@ package command
 
 import (
 	"bytes"
-	"context"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/alessio/shellescape"
 	"github.com/imarsman/concur/cmd/awk"
+	"github.com/imarsman/concur/cmd/joblog"
 	"github.com/imarsman/concur/cmd/parse"
+	"github.com/imarsman/concur/cmd/reorder"
 	"github.com/imarsman/concur/cmd/tasks"
-	"golang.org/x/sync/semaphore"
 )
 
-var sem *semaphore.Weighted
-
-func init() {
-	sem = semaphore.NewWeighted(8)
-}
-
-var once sync.Once
-
-// SetConcurrency allow concurrency to be set
-func (c *Command) SetConcurrency(concurrency int64) {
-	once.Do(func() {
-		sem = semaphore.NewWeighted(concurrency)
-	})
-}
-
 // Config config parameters
 type Config struct {
-	Awk         *awk.Command // awk script to use
-	Slots       int64
-	DryRun      bool
-	KeepOrder   bool
-	Concurrency int64
-	PrintEmpty  bool
-	ExitOnError bool
-	StdIn       bool
+	Awk          *awk.Command // awk script to use
+	Slots        int64
+	DryRun       bool
+	KeepOrder    bool
+	Concurrency  int64
+	PrintEmpty   bool
+	StdIn        bool
+	Pipe         bool                      // stream items are chunks of input sent to stdin as they are
+	Timeout      *Timeout                  // optional per job time limit
+	Retry        *Retry                    // optional retry policy for failed jobs
+	JobLog       *joblog.Log               // optional log of finished jobs
+	Order        *reorder.Buffer           // optional buffer to write job output in sequence order
+	Tag          bool                      // prefix output lines with the job's input
+	TagString    string                    // template for the prefix for output lines
+	LineBuffer   bool                      // write output lines as they are produced
+	JSON         bool                      // write a JSON object with the result of each job
+	Halt         *Halt                     // optional policy for stopping early
+	Tally        *Tally                    // optional count of finished and failed jobs
+	Skip         func(sequence int64) bool // optional check for jobs to skip, such as those finished in an earlier run
+	Executor     Executor                  // starts the process for each job, bash if not set
+	MaxArgs      int                       // most inputs to batch into one job, with {} expanding to all of them
+	MaxLength    int                       // batch as many inputs as fit in a command of this length
+	ColSep       *regexp.Regexp            // split input lines into fields for numbered tokens
+	Header       []string                  // names of fields for tokens such as {name}
+	HeaderLine   bool                      // use the first input line for the names of fields
+	Match        *regexp.Regexp            // take fields and their names from the groups of a match of each input line
+	MatchStrict  bool                      // report input lines that don't match
+	TextTemplate *template.Template        // command written as a Go text template instead of with tokens
+	Expressions  *awk.Expressions          // compiled awk expressions for {= awk: ... =} tokens
 }
 
 // Command a command
@@ -55,6 +60,21 @@ type Command struct {
 	Config   Config
 	Sequence int64
 	Empty    bool
+	Template string       // command before placeholders were replaced
+	Tasks    []tasks.Task // tasks used to replace placeholders
+	Attempt  int          // attempt number starting at 1
+	ExitCode int
+	Signal   int
+	Start    time.Time
+	Duration time.Duration
+	Received int64 // bytes written to stdout by the command
+	TimedOut bool
+	out      *output
+	tag      string // prefix for output lines
+	streamed bool   // output of the last attempt was written line by line as it was produced
+	slot     int64  // slot the executor ran the last attempt in if it chose one, otherwise 0
+	raw      bool   // replace placeholders without quoting and without supplying missing tokens
+	runner   *Runner
 }
 
 // NewCommand create a new command struct instance
@@ -103,9 +123,20 @@ func (c *Command) Copy() (newCommand Command) {
 }
 
 // GetSlotNumber get slot number based on sequence and concurrency
+// A retried job moves on by one slot for each retry. A slot chosen by the executor when the job ran is used instead.
 func (c Command) GetSlotNumber() int64 {
+	if c.slot > 0 {
+		return c.slot
+	}
+	// Without slots there is only one
+	if c.Slots < 1 {
+		return 1
+	}
 	var slotNumber = c.Slots
 	var sequence = c.Sequence
+	if c.Attempt > 1 {
+		sequence += int64(c.Attempt - 1)
+	}
 	if int64(c.Slots) <= sequence {
 		slotNumber = sequence % c.Slots
 	} else {
@@ -136,7 +167,15 @@ func getParams(regEx *regexp.Regexp, input string) (paramsMap map[string]string)
 }
 
 // Prepare replace placeholders with data from incoming
+// The command is kept as a template so it can be prepared again for a retry.
 func (c *Command) Prepare(tasks []tasks.Task) (err error) {
+	if c.Template == "" {
+		c.Template = c.Command
+	} else {
+		c.Command = c.Template
+	}
+	c.Tasks = tasks
+
 	var taskStrings []string
 	for _, t := range tasks {
 		taskStrings = append(taskStrings, t.Task)
@@ -152,12 +191,20 @@ func (c *Command) Prepare(tasks []tasks.Task) (err error) {
 		return
 	}
 
+	// A command template does its own formatting and quoting
+	if c.Config.TextTemplate != nil && !c.raw {
+		return c.renderTemplate(tasks)
+	}
+
 	defaultTask := tasks[0]
+	// Items for numbered tokens, which are the fields of the input when there is a column separator
+	items := c.fields(tasks)
 	// If there is something besides a token but no tokens we don't want to interpolate tokens later
 	var commandStringEmpty = true
 
 	// If empty, flag that
-	if c.Command == "" {
+	// A raw command is only a template for text so is never quoted or run.
+	if c.Command == "" || c.raw {
 		c.Empty = true
 	} else {
 		// allow no command to be run but line reformatted if the only space delimite things in the line are {} type
@@ -175,19 +222,20 @@ func (c *Command) Prepare(tasks []tasks.Task) (err error) {
 	}
 
 	// look for tokens except for {#} and {%}
-	var foundToken = parse.REToken.MatchString(c.Command)
+	var foundToken = parse.REToken.MatchString(c.Command) || c.hasNamedToken() ||
+		parse.REAwkToken.MatchString(c.Command)
 
 	// If no tokens, supply them
 	// With an empty command the result will be the placement of the incoming value
-	if !foundToken && !c.Config.StdIn {
+	if !foundToken && !c.Config.StdIn && !c.raw {
 		var sb strings.Builder
-		if len(tasks) == 1 {
+		if len(items) == 1 {
 			_, err = sb.WriteString("{}")
 			if err != nil {
 				return
 			}
 		} else {
-			for i := range tasks {
+			for i := range items {
 				_, err = sb.WriteString(fmt.Sprintf("{%d} ", i+1))
 				if err != nil {
 					return
@@ -213,84 +261,9 @@ func (c *Command) Prepare(tasks []tasks.Task) (err error) {
 
 	// replaceToken replace a token with a replacement string for the command
 	var replaceToken = func(pattern string, replace string) {
-		if len(tasks) == 1 {
-			for strings.Contains(c.Command, pattern) {
-				c.Command = strings.Replace(c.Command, pattern, replace, 1)
-			}
-		} else {
-			for strings.Contains(c.Command, pattern) {
-				c.Command = strings.ReplaceAll(c.Command, pattern, replace)
-			}
-		}
-	}
-
-	// {}
-	// Input line
-	if strings.Contains(c.Command, parse.TokenInputLine) {
-		replacement := defaultTask.Task
-
-		if c.Empty {
-			replaceToken(parse.TokenInputLine, replacement)
-		} else {
-			replaceToken(parse.TokenInputLine, shellescape.Quote(replacement))
-		}
+		c.Command = strings.ReplaceAll(c.Command, pattern, replace)
 	}
 
-	// {.}
-	// Input line without extension.
-	if strings.Contains(c.Command, parse.TokenInputLineNoExtension) {
-		dir := filepath.Dir(defaultTask.Task)
-		base := filepath.Base(defaultTask.Task)
-		noExtension := strings.TrimSuffix(base, filepath.Ext(base))
-		replacement := filepath.Join(dir, noExtension)
-
-		if c.Empty {
-			replaceToken(parse.TokenInputLine, replacement)
-		} else {
-			replaceToken(parse.TokenInputLine, shellescape.Quote(replacement))
-		}
-	}
-
-	// {/}
-	// Basename of input line.
-	if strings.Contains(c.Command, parse.TokenBaseName) {
-		replacement := defaultTask.Task
-
-		if c.Empty {
-			replaceToken(parse.TokenBaseName, replacement)
-		} else {
-			replaceToken(parse.TokenBaseName, shellescape.Quote(replacement))
-		}
-	}
-
-	// {//}
-	// Dirname of output line.
-	if strings.Contains(c.Command, parse.TokenDirname) {
-		replacement := defaultTask.Task
-
-		if c.Empty {
-			replaceToken(parse.TokenDirname, replacement)
-		} else {
-			replaceToken(parse.TokenDirname, shellescape.Quote(replacement))
-		}
-
-		replaceToken(parse.TokenDirname, filepath.Dir(shellescape.Quote(defaultTask.Task)))
-	}
-
-	// {/.}
-	// Basename of input line without extension.
-	if strings.Contains(c.Command, parse.TokenBaseNameNoExtension) {
-		replacement := defaultTask.Task
-
-		base := filepath.Base(replacement)
-		replacement = strings.TrimSuffix(base, filepath.Ext(base))
-
-		if c.Empty {
-			replaceToken(parse.TokenBaseNameNoExtension, replacement)
-		} else {
-			replaceToken(parse.TokenBaseNameNoExtension, shellescape.Quote(replacement))
-		}
-	}
 	// {#}
 	// Sequence number of the job to run.
 	if strings.Contains(c.Command, parse.TokenSequence) {
@@ -313,365 +286,338 @@ func (c *Command) Prepare(tasks []tasks.Task) (err error) {
 		}
 	}
 
-	// Remove this after ensuring it is OK
-	if len(tasks) >= 1 {
-		var found bool
-		var number int
-
-		// {n}
-		// Argument from input source n or the n'th argument.
-		// Note - nth argument handling not implemented.
-		found, number, err = parse.NumberFromToken(parse.RENumbered, c.Command)
-		if err != nil {
-			return
-		}
-		if found {
-			for {
-				found, number, err = parse.NumberFromToken(parse.RENumbered, c.Command)
-				if err != nil {
-					return
-				}
-				if !found {
-					break
-				}
-
-				if len(tasks) < number {
-					err = fmt.Errorf(
-						"task item {%d} for task list count %d out of range",
-						number,
-						len(tasks),
-					)
-					return
-				}
-				if number > len(tasks) {
-					break
-				}
-				task := tasks[number-1]
-
-				// Avoid endless loop
-				if parse.RENumbered.MatchString(task.Task) {
-					err = fmt.Errorf(
-						"item %s matches regular expression %s",
-						task.Task,
-						parse.RENumbered.String(),
-					)
-					return
-				}
-
-				replacement := task.Task
-
-				if c.Empty {
-					c.Command = strings.ReplaceAll(c.Command, fmt.Sprintf(`{%d}`, number), replacement)
-				} else {
-					c.Command = strings.ReplaceAll(
-						c.Command, fmt.Sprintf(`{%d}`, number), shellescape.Quote(replacement),
-					)
-				}
+	// {}, {.}, {/}, {//}, {/.} and the same with a number or header name such as {2}, {2/.} or {name/}
+	// Tokens without a number use the input line. Numbered tokens use a task list item or with a column separator a
+	// field of the input line. Named tokens use the field with that name in the header.
+	// {= awk: expression =}
+	// Value of an awk expression for the input line, with NR set to the sequence number.
+	// All are replaced in one pass so replacements are never scanned for tokens.
+	var out strings.Builder
+	var last int
+	for _, match := range parse.RESubstitutionToken.FindAllStringSubmatchIndex(c.Command, -1) {
+		out.WriteString(c.Command[last:match[0]])
+		last = match[1]
+
+		if match[2] >= 0 {
+			var value string
+			value, err = c.Config.Expressions.Evaluate(
+				strings.TrimSpace(c.Command[match[2]:match[3]]),
+				defaultTask.Task,
+				// awk counts the line it reads
+				"NR", fmt.Sprint(sequence-1),
+				"slot", fmt.Sprint(c.GetSlotNumber()),
+			)
+			if err != nil {
+				return
+			}
+			if c.Empty {
+				out.WriteString(value)
+			} else {
+				out.WriteString(shellescape.Quote(value))
 			}
+			continue
 		}
 
-		// {n.}
-		// Argument from input source n or the n'th argument without extension.
-		// Note - nth argument handling not implemented.
-		found, number, err = parse.NumberFromToken(parse.RENumberedWithNoExtension, c.Command)
-		if err != nil {
-			return
+		number := c.Command[match[4]:match[5]]
+		modifier := ""
+		if match[6] >= 0 {
+			modifier = c.Command[match[6]:match[7]]
 		}
-		if found {
-			for {
-				found, number, err = parse.NumberFromToken(parse.RENumberedWithNoExtension, c.Command)
-				if err != nil {
-					return
-				}
-				if !found {
-					break
-				}
-
-				if len(tasks) < number {
-					err = fmt.Errorf(
-						"task item {%d.} for task list count %d out of range",
-						number,
-						len(tasks),
-					)
-					return
-				}
-
-				if number > len(tasks) {
-					break
-				}
-				task := tasks[number-1]
-
-				dir := filepath.Dir(task.Task)
-				base := filepath.Base(task.Task)
-				noExtension := strings.TrimSuffix(base, filepath.Ext(base))
-				replacement := filepath.Join(dir, noExtension)
-
-				// Avoid endless loop
-				if parse.RENumberedWithNoExtension.MatchString(task.Task) {
-					err = fmt.Errorf("item %s matches regular expression %s", replacement, parse.RENumberedWithNoExtension.String())
-					return
-				}
-
-				if c.Empty {
-					c.Command = strings.ReplaceAll(c.Command, fmt.Sprintf(`{%d.}`, number), replacement)
-				} else {
-					c.Command = strings.ReplaceAll(
-						c.Command, fmt.Sprintf(`{%d.}`, number), shellescape.Quote(replacement),
-					)
-				}
+		task := defaultTask
+		if parse.IsName(number) {
+			// Only names in the header are tokens, leaving the likes of ${HOME} alone
+			field := c.headerField(number)
+			if field < 0 {
+				out.WriteString(c.Command[match[0]:match[1]])
+				continue
 			}
+			if field >= len(items) {
+				err = fmt.Errorf("field {%s} missing from %q", number, defaultTask.Task)
+				return
+			}
+			task = items[field]
+		} else if number != "" {
+			var n int
+			n, err = strconv.Atoi(number)
+			if err != nil {
+				return
+			}
+			if n < 1 || n > len(items) {
+				err = fmt.Errorf(
+					"task item {%s%s} for task list count %d out of range",
+					number,
+					modifier,
+					len(items),
+				)
+				return
+			}
+			task = items[n-1]
 		}
+		out.WriteString(c.render(task, parse.Modifiers[modifier]))
+	}
+	out.WriteString(c.Command[last:])
+	c.Command = out.String()
 
-		// {n/}
-		// Basename of argument from input source n or the n'th argument.
-		// Note - nth argument handling not implemented.
-		found, number, err = parse.NumberFromToken(parse.RENumberedBasename, c.Command)
-		if err != nil {
-			return
-		}
-		if found {
-			for {
-				found, number, err = parse.NumberFromToken(parse.RENumberedBasename, c.Command)
-				if err != nil {
-					return
-				}
-				if !found {
-					break
-				}
-
-				if len(tasks) < number {
-					err = fmt.Errorf(
-						"task item {%d/} for task list count %d out of range",
-						number,
-						len(tasks),
-					)
-					return
-				}
-				if number > len(tasks) {
-					break
-				}
-				task := tasks[number-1]
-
-				replacement := filepath.Base(task.Task)
+	return
+}
 
-				// Avoid endless loop
-				if parse.RENumberedBasename.MatchString(task.Task) {
-					err = fmt.Errorf(
-						"item %s matches regular expression %s",
-						replacement,
-						parse.RENumberedBasename.String(),
-					)
-					return
-				}
-				if c.Empty {
-					c.Command = strings.ReplaceAll(c.Command, fmt.Sprintf(`{%d/}`, number), replacement)
-				} else {
-					c.Command = strings.ReplaceAll(
-						c.Command, fmt.Sprintf(`{%d/}`, number), shellescape.Quote(replacement),
-					)
-				}
-			}
+// headerField get the index of the field with a name in the header or a named group of the match, or -1 if there is
+// no such field
+func (c *Command) headerField(name string) int {
+	if c.Config.Match != nil {
+		// Groups start at 1
+		return c.Config.Match.SubexpIndex(name) - 1
+	}
+	for i, v := range c.Config.Header {
+		if v == name {
+			return i
 		}
+	}
 
-		// {n//}
-		// Dirname of argument from input source n or the n'th argument.
-		// Note - nth argument handling not implemented.
-		found, number, err = parse.NumberFromToken(parse.RENumberedDirname, c.Command)
-		if err != nil {
-			return
-		}
-		if found {
-			for {
-				found, number, err = parse.NumberFromToken(parse.RENumberedDirname, c.Command)
-				if err != nil {
-					return
-				}
-				if !found {
-					break
-				}
+	return -1
+}
 
-				if len(tasks) < number {
-					err = fmt.Errorf(
-						"task item {%d//} for task list count %d out of range",
-						number,
-						len(tasks),
-					)
-					return
-				}
-				if number > len(tasks) {
-					break
-				}
-				task := tasks[number-1]
+// hasNamedToken check for a token with a name from the header
+func (c *Command) hasNamedToken() bool {
+	for _, match := range parse.REInputToken.FindAllStringSubmatch(c.Command, -1) {
+		if parse.IsName(match[1]) && c.headerField(match[1]) >= 0 {
+			return true
+		}
+	}
 
-				replacent := filepath.Dir(task.Task)
+	return false
+}
 
-				// Avoid endless loop
-				if parse.RENumberedDirname.MatchString(task.Task) {
-					err = fmt.Errorf(
-						"item %s matches regular expression %s",
-						task.Task, replacent,
-					)
-					return
-				}
-				replacement := filepath.Dir(task.Task)
-
-				if c.Empty {
-					c.Command = strings.ReplaceAll(c.Command, fmt.Sprintf(`{%d//}`, number), replacement)
-				} else {
-					c.Command = strings.ReplaceAll(
-						c.Command, fmt.Sprintf(`{%d//}`, number), shellescape.Quote(replacement),
-					)
-				}
+// fields get the fields of each task split with the column separator, or the tasks themselves without one
+// With a match the groups matched in the first task are its fields.
+func (c *Command) fields(taskSet []tasks.Task) (fields []tasks.Task) {
+	if c.Config.Match != nil && len(taskSet) > 0 {
+		groups := c.Config.Match.FindStringSubmatch(taskSet[0].Task)
+		if len(groups) > 0 {
+			for _, group := range groups[1:] {
+				fields = append(fields, *tasks.NewTask(group))
 			}
 		}
-
-		// {n/.}
-		// Basename of argument from input source n or the n'th argument without extension.
-		// Note - nth argument handling not implemented.
-		found, number, err = parse.NumberFromToken(parse.RENumberedBasenameNoExtension, c.Command)
-		if err != nil {
-			return
+		return append(fields, taskSet[1:]...)
+	}
+	if c.Config.ColSep == nil {
+		return taskSet
+	}
+	for _, t := range taskSet {
+		for _, field := range c.Config.ColSep.Split(t.Task, -1) {
+			fields = append(fields, *tasks.NewTask(field))
 		}
-		if found {
-			for {
-				found, number, err = parse.NumberFromToken(parse.RENumberedBasenameNoExtension, c.Command)
-				if err != nil {
-					return
-				}
-				if !found {
-					break
-				}
-
-				if len(tasks) < number {
-					err = fmt.Errorf(
-						"task item {%d/.} for task list count %d out of range",
-						number,
-						len(tasks),
-					)
-					return
-				}
-				if number > len(tasks) {
-					break
-				}
-				task := tasks[number-1]
-
-				base := filepath.Base(task.Task)
-				replacement := strings.TrimSuffix(base, filepath.Ext(base))
+	}
 
-				// Avoid endless loop
-				if parse.RENumberedBasenameNoExtension.MatchString(task.Task) {
-					err = fmt.Errorf("item %s matches regular expression %s", replacement, parse.RENumberedBasenameNoExtension.String())
-					return
-				}
+	return
+}
 
-				if c.Empty {
-					c.Command = strings.ReplaceAll(c.Command, fmt.Sprintf(`{%d./}`, number), replacement)
-				} else {
-					c.Command = strings.ReplaceAll(
-						c.Command, fmt.Sprintf(`{%d./}`, number), shellescape.Quote(replacement),
-					)
-				}
-			}
+// render get the replacement for a token from a task, changing each of the task's items such as by removing the
+// extension. Several items are separated by spaces. Items are quoted unless the command only formats text.
+func (c *Command) render(task tasks.Task, change func(string) string) string {
+	items := task.Items
+	if items == nil {
+		items = []string{task.Task}
+	}
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		item = change(item)
+		if !c.Empty {
+			item = shellescape.Quote(item)
 		}
+		parts = append(parts, item)
 	}
 
-	return
+	return strings.Join(parts, " ")
 }
 
-// RunCommand run all items in task lists against RunCommand
-func RunCommand(c Command, taskSet []tasks.Task, wg *sync.WaitGroup) (err error) {
+// executor get the executor to start jobs with
+func (c *Command) executor() Executor {
+	if c.Config.Executor == nil {
+		return NewShellExecutor(DefaultShell)
+	}
 
-	ctx := context.Background()
+	return c.Config.Executor
+}
 
-	err = c.Prepare(taskSet)
-	if err != nil {
-		fmt.Println(err)
-		return
+// run run the command once, returning what it wrote to stdout and stderr
+func (c *Command) run() (outStr, errStr string, err error) {
+	var buffStdOut bytes.Buffer
+	var buffStdErr bytes.Buffer
+
+	c.slot = 0
+	job := Job{
+		Command:  c.Command,
+		Sequence: c.GetSequence(),
+		Slot:     c.GetSlotNumber(),
+		Stdout:   &buffStdOut,
+		Stderr:   &buffStdErr,
 	}
 
-	// Acquire weight of one from semaphore
-	err = sem.Acquire(ctx, 1)
-	if err != nil {
-		panic(err)
+	// If stdin was specified, send the input to the command's stdin
+	if c.Config.StdIn {
+		job.Stdin = strings.NewReader(c.Input)
 	}
 
-	// Run and release waitgroup for overall processing and semaphore for concurrency
-	var run = func() {
-		defer wg.Done()
-		defer sem.Release(1)
-		err = c.Execute()
-		if err != nil {
-			return
+	// With line buffering output lines are written as they are produced rather than when the job is done
+	// Output from an attempt that may be retried is held so only the final attempt's output is written, and an awk
+	// script is run once against all of a job's output.
+	var stdoutLines, stderrLines *lineWriter
+	c.streamed = c.Config.LineBuffer && c.Config.Awk == nil && !c.Config.Retry.mayRetry(c.Attempt)
+	if c.streamed {
+		stdoutLines = newLineWriter(c, false)
+		stderrLines = newLineWriter(c, true)
+		job.Stdout = stdoutLines
+		job.Stderr = stderrLines
+		defer func() {
+			stdoutLines.Close()
+			stderrLines.Close()
+			c.Received = stdoutLines.written
+		}()
+	}
+
+	c.TimedOut = false
+	c.ExitCode = 0
+	c.Signal = 0
+	c.Start = time.Now()
+	proc, err := c.executor().Start(job)
+	if err == nil {
+		c.runner.track(proc)
+		err = c.wait(proc)
+		c.runner.untrack(proc)
+		// Record where the job actually ran if the executor chose that
+		if placer, ok := proc.(Placer); ok {
+			c.slot, c.Command = placer.Placement()
 		}
 	}
+	c.Duration = time.Since(c.Start)
+	c.ExitCode, c.Signal = exitStatus(err)
+	// The JSON result records a timeout
+	if c.TimedOut && !c.Config.JSON {
+		c.Print(os.Stderr, fmt.Sprintf("job %d %v: %s", c.GetSequence(), err, c.Command))
+	}
 
-	// Run as go process. Will run ordered if semaphore count set to 1
-	go run()
+	// Don't print anything now. Wait until awk script stage.
+	outStr = buffStdOut.String()
+	errStr = buffStdErr.String()
+	c.Received = int64(buffStdOut.Len())
 
 	return
 }
 
+// logJob add the finished job to the job log if there is one
+func (c *Command) logJob() {
+	if c.Config.JobLog == nil {
+		return
+	}
+	entry := joblog.Entry{
+		Sequence: c.GetSequence(),
+		Slot:     c.GetSlotNumber(),
+		Host:     joblog.LocalHost,
+		Start:    c.Start,
+		Runtime:  c.Duration,
+		Receive:  c.Received,
+		ExitCode: c.ExitCode,
+		Signal:   c.Signal,
+		Command:  c.Command,
+	}
+	if c.Config.StdIn {
+		entry.Send = int64(len(c.Input))
+	}
+	err := c.Config.JobLog.Write(entry)
+	if err != nil {
+		c.Print(os.Stderr, fmt.Sprintf("job log: %v", err))
+	}
+}
+
 // Execute execute a shell command
-// For now, returns the stdout and stderr.
+// Failed runs are retried according to the retry policy and only the output of the final attempt is used.
 // Sends stdout and stderr to system stdout and stderr.
-// func (c *Command) Execute() (stdout, stdErr string, err error) {
 func (c *Command) Execute() (err error) {
 	outStr := c.Command
 	errStr := ""
 
-	// If the command started out as "" don't try to run command, otherwise run
-	if !c.Empty {
-		var buffStdOut bytes.Buffer
-		var buffStdErr bytes.Buffer
+	if c.Attempt == 0 {
+		c.Attempt = 1
+	}
 
-		cmd := exec.Command("bash", "-c", c.Command)
+	// Send the result to the runner once output has been written
+	var result *Result
+	defer func() {
+		if result != nil {
+			c.runner.send(*result)
+		}
+	}()
 
-		// If stdin was specified, send the input to the command's stdin
-		if c.Config.StdIn {
-			// https://stackoverflow.com/questions/23166468/how-can-i-get-stdin-to-exec-cmd-in-golang
-			stdin, stdinErr := cmd.StdinPipe()
-			if stdinErr != nil {
-				err = stdinErr
-				return
-			}
+	// Collect output and write it when the job is done
+	c.out = new(output)
+	defer c.flush()
 
-			go func() {
-				defer stdin.Close()
-				io.WriteString(stdin, c.Input)
-			}()
-		}
+	c.tag, err = c.renderTag()
+	if err != nil {
+		return
+	}
 
-		cmd.Stdout = &buffStdOut
-		cmd.Stderr = &buffStdErr
+	// If the command started out as "" don't try to run command, otherwise run
+	if !c.Empty {
 		// If we are on a dry run print out what would be run, otherwise run the command.
-		if !c.Config.DryRun {
-			err = cmd.Run()
-			if err != nil {
-				if c.Config.ExitOnError {
-					c.Print(os.Stderr, fmt.Sprintf("%v", err))
-					os.Exit(1)
-				}
-			}
-		} else {
+		if c.Config.DryRun {
 			// with dry-run print out command and return
-			fmt.Println(strings.TrimSpace(cmd.String()))
+			c.Print(os.Stdout, c.executor().Describe(c.Command))
 			return
 		}
-		// Don't print anything now. Wait until awk script stage.
-		outStr = buffStdOut.String()
-		errStr = buffStdErr.String()
+		for {
+			outStr, errStr, err = c.run()
+			if err == nil || !c.Config.Retry.shouldRetry(c.Attempt, c.ExitCode) {
+				break
+			}
+			if !c.runner.pause(c.Config.Retry.delay(c.Attempt), c.Config.Halt) {
+				break
+			}
+			c.Attempt++
+			// Prepare again as tokens such as {%} can change between attempts
+			if len(c.Tasks) > 0 {
+				err = c.Prepare(c.Tasks)
+				if err != nil {
+					return
+				}
+				c.tag, err = c.renderTag()
+				if err != nil {
+					return
+				}
+			}
+		}
+		c.logJob()
+		c.Config.Tally.Record(c, err)
+		if c.Config.Halt.Record(err != nil, c.ExitCode) {
+			if c.Config.Halt.Now {
+				go c.runner.killRunning()
+			}
+			if err != nil {
+				c.Print(os.Stderr, fmt.Sprintf("halting after job %d failed: %v", c.GetSequence(), err))
+			}
+		}
+	} else {
+		// Nothing is run but the job still counts as finished
+		c.Start = time.Now()
+		c.logJob()
+		c.Config.Tally.Record(c, nil)
+	}
+
+	finished := c.Result(outStr, errStr)
+	result = &finished
+
+	if c.Config.JSON {
+		return c.printJSON(outStr, errStr)
 	}
 
 	// Run awk against what has been produced so far
 	// Print out result
 	if c.Config.Awk != nil {
-		outStr, err = c.Config.Awk.Execute(outStr)
+		outStr, err = c.Config.Awk.Execute(outStr, "attempt", strconv.Itoa(c.Attempt))
 		if err != nil {
 			errStr := fmt.Sprintf("%v", err)
 			c.Print(os.Stderr, errStr)
-			if c.Config.ExitOnError {
-				os.Exit(1)
-			}
 		}
 		if outStr == "" && c.Config.PrintEmpty {
 			if c.Config.PrintEmpty {
@@ -685,6 +631,15 @@ func (c *Command) Execute() (err error) {
 		}
 	} else {
 		// No awk script so print output from command run
+		if c.streamed && !c.Empty {
+			// Output has already been written line by line
+			return
+		}
+		if c.Config.Pipe && !c.Empty && c.tag == "" {
+			c.printRaw(os.Stdout, outStr)
+			c.printRaw(os.Stderr, errStr)
+			return
+		}
 		if len(outStr) > 0 {
 			c.Print(os.Stdout, outStr)
 		} else if len(outStr) == 0 {
@@ -699,16 +654,3 @@ func (c *Command) Execute() (err error) {
 
 	return
 }
-
-var printWG = new(sync.WaitGroup)
-
-// Print send to output
-func (c *Command) Print(file *os.File, str string) {
-	// Optional waitgroup for printing output in order
-	printWG.Wait()
-	if c.Config.KeepOrder {
-		printWG.Add(1)
-		defer printWG.Done()
-	}
-	fmt.Fprintln(file, strings.TrimSpace(str))
-}
@@ -1,8 +1,19 @@
 package command
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/imarsman/concur/cmd/awk"
+	"github.com/imarsman/concur/cmd/tasks"
 	"github.com/matryer/is"
 )
 
@@ -15,6 +26,465 @@ func TestCommand(t *testing.T) {
 	is.NoErr(err)
 }
 
+func TestTimeout(t *testing.T) {
+	is := is.New(t)
+
+	timeout, err := NewTimeout("2s", time.Second)
+	is.NoErr(err)
+	is.Equal(timeout.Limit(), 2*time.Second)
+
+	timeout, err = NewTimeout("200%", time.Second)
+	is.NoErr(err)
+	// No limit until enough jobs have completed
+	is.Equal(timeout.Limit(), time.Duration(0))
+	timeout.Record(3 * time.Second)
+	timeout.Record(1 * time.Second)
+	timeout.Record(2 * time.Second)
+	is.Equal(timeout.Limit(), 4*time.Second)
+
+	_, err = NewTimeout("soon", time.Second)
+	is.True(err != nil)
+}
+
+func TestCommandTimeout(t *testing.T) {
+	is := is.New(t)
+
+	timeout, err := NewTimeout("100ms", 100*time.Millisecond)
+	is.NoErr(err)
+
+	command := Command{}
+	command.Command = "sleep 5"
+	command.Config.Timeout = timeout
+	err = command.Execute()
+	is.True(err != nil)
+	is.True(command.TimedOut)
+}
+
+func TestRetry(t *testing.T) {
+	is := is.New(t)
+
+	retry := Retry{Retries: 2, Delay: time.Millisecond, Backoff: true, ExitCodes: []int{75}}
+	is.True(retry.shouldRetry(1, 75))
+	is.True(!retry.shouldRetry(1, 1))
+	is.True(!retry.shouldRetry(3, 75))
+	is.Equal(retry.delay(3), 4*time.Millisecond)
+
+	command := Command{}
+	command.Command = "exit 75"
+	command.Config.Retry = &retry
+	err := command.Execute()
+	is.True(err != nil)
+	is.Equal(command.Attempt, 3)
+	is.Equal(command.ExitCode, 75)
+}
+
+func TestPreparePaths(t *testing.T) {
+	is := is.New(t)
+
+	command := NewCommand("echo {} {.} {/} {//} {/.}", nil, Config{Slots: 1})
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("dir/file.txt")})
+	is.NoErr(err)
+	is.Equal(command.Command, "echo dir/file.txt dir/file file.txt dir file")
+}
+
+func TestModifierTokens(t *testing.T) {
+	is := is.New(t)
+
+	// Each modifier only changes its own token
+	for command, expected := range map[string]string{
+		"{}":   "dir/a.txt",
+		"{.}":  "dir/a",
+		"{/}":  "a.txt",
+		"{//}": "dir",
+		"{/.}": "a",
+	} {
+		c := NewCommand("echo "+command, nil, Config{Slots: 1})
+		err := c.Prepare([]tasks.Task{*tasks.NewTask("dir/a.txt")})
+		is.NoErr(err)
+		is.Equal(c.Command, "echo "+expected)
+	}
+}
+
+func TestColSep(t *testing.T) {
+	is := is.New(t)
+
+	config := Config{Slots: 1, ColSep: regexp.MustCompile(`\t`)}
+	command := NewCommand("cp {1} {2/} {3.} {3/.} {3./} # {}", nil, config)
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("a b\tdir/x\tc/d.txt")})
+	is.NoErr(err)
+	is.Equal(command.Command, "cp 'a b' x c/d d d # 'a b\tdir/x\tc/d.txt'")
+
+	// Without tokens every field is added
+	command = NewCommand("echo", nil, config)
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("1\t2")})
+	is.NoErr(err)
+	is.Equal(command.Command, "echo 1 2")
+
+	command = NewCommand("echo {3}", nil, config)
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("1\t2")})
+	is.True(err != nil)
+}
+
+func TestHeader(t *testing.T) {
+	is := is.New(t)
+
+	// Names come from the first line and only names in the header are tokens
+	taskList := tasks.NewTaskList()
+	taskList.Add("name path", "apple fruit/red.txt", "kiwi fruit/green.txt")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	fake := new(FakeExecutor)
+	config := Config{Slots: 1, ColSep: regexp.MustCompile(`\s+`), HeaderLine: true, Executor: fake}
+	runner := NewRunner("echo {name} {path/.} ${HOME}", config)
+	runner.Stdout = io.Discard
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(fake.Commands(), []string{"echo apple red ${HOME}", "echo kiwi green ${HOME}"})
+
+	// Names can be given
+	config = Config{Slots: 1, ColSep: regexp.MustCompile(`,`), Header: []string{"a", "b"}}
+	command := NewCommand("{b}-{a}", nil, config)
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("1,2")})
+	is.NoErr(err)
+	is.Equal(command.Command, "2-1")
+}
+
+func TestMatch(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("1.2.3.4 GET /a", "junk", "5.6.7.8 POST /b")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	// Named and numbered groups are tokens and lines that don't match are skipped
+	fake := new(FakeExecutor)
+	config := Config{Slots: 1, Match: regexp.MustCompile(`^(?P<ip>\S+) (\w+) (?P<path>\S+)`), Executor: fake}
+	runner := NewRunner("lookup {ip} {path/} {2}", config)
+	runner.Stdout = io.Discard
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(fake.Commands(), []string{"lookup 1.2.3.4 a GET", "lookup 5.6.7.8 b POST"})
+
+	// Lines that don't match fail the run when matching is strict
+	var stderr bytes.Buffer
+	tally := NewTally()
+	config.MatchStrict = true
+	config.Tally = tally
+	runner = NewRunner("lookup {ip}", config)
+	runner.Stdout = io.Discard
+	runner.Stderr = &stderr
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(stderr.String(), "no match for input: junk\n")
+	is.Equal(tally.Failed(), int64(0))
+	is.Equal(tally.Summary(), "1 input lines did not match\n")
+	status, err := tally.ExitStatus(ExitStatusCount)
+	is.NoErr(err)
+	is.Equal(status, 1)
+}
+
+func TestTemplate(t *testing.T) {
+	is := is.New(t)
+
+	tmpl, err := NewTemplate(`cp {{quote .Line}} {{index .Args 1}}/{{pad 3 .Seq}}-{{.Line | base | noext | upper}}{{if gt .Slot 1}}!{{end}}`)
+	is.NoErr(err)
+	command := NewCommand("", nil, Config{Slots: 1, TextTemplate: tmpl})
+	command.Sequence = 7
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("a dir/x.txt"), *tasks.NewTask("out")})
+	is.NoErr(err)
+	is.Equal(command.Command, "cp 'a dir/x.txt' out/007-X")
+	is.True(!command.Empty)
+
+	// Batched items can be quoted one by one
+	tmpl, err = NewTemplate(`rm{{range .Items}} {{quote .}}{{end}} {{len .Args}}`)
+	is.NoErr(err)
+	command = NewCommand("", nil, Config{Slots: 1, TextTemplate: tmpl})
+	err = command.Prepare(tasks.Merge([][]tasks.Task{{*tasks.NewTask("a b")}, {*tasks.NewTask("c")}}))
+	is.NoErr(err)
+	is.Equal(command.Command, "rm 'a b' c 1")
+
+	// A job whose template fails counts as failed
+	tmpl, err = NewTemplate(`echo {{.Nope}}`)
+	is.NoErr(err)
+	taskList := tasks.NewTaskList()
+	taskList.Add("a")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+	tally := NewTally()
+	runner := NewRunner("", Config{Slots: 1, TextTemplate: tmpl, Tally: tally})
+	runner.Stderr = io.Discard
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(tally.Failed(), int64(1))
+}
+
+func TestRetrySlot(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("a", "b")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	// Another job uses the only slot while a failed job waits to be retried
+	var failed bool
+	fake := &FakeExecutor{Run: func(ctx context.Context, job Job) int {
+		if job.Command == "echo a" && !failed {
+			failed = true
+			return 1
+		}
+		return 0
+	}}
+	runner := NewRunner("echo {}", Config{Slots: 1, Executor: fake, Retry: &Retry{Retries: 1, Delay: 100 * time.Millisecond}})
+	runner.Stdout = io.Discard
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(fake.Commands(), []string{"echo a", "echo b", "echo a"})
+}
+
+func TestLineBufferRetry(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("a")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	// Only the output of the final attempt is written
+	var attempts int
+	fake := &FakeExecutor{Run: func(ctx context.Context, job Job) int {
+		attempts++
+		fmt.Fprintf(job.Stdout, "attempt %d\n", attempts)
+		if attempts < 3 {
+			return 1
+		}
+		return 0
+	}}
+	var stdout bytes.Buffer
+	runner := NewRunner("echo {}", Config{Slots: 1, Executor: fake, LineBuffer: true, Retry: &Retry{Retries: 2}})
+	runner.Stdout = &stdout
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(stdout.String(), "attempt 3\n")
+
+	// An awk script sees all of a job's output at once
+	awkCommand, err := awk.NewCommand(`BEGIN { print "begin" } { print "line " $0 }`)
+	is.NoErr(err)
+	stdout.Reset()
+	runner = NewRunner("printf '1\\n2\\n'", Config{Slots: 1, LineBuffer: true, Awk: awkCommand})
+	runner.Stdout = &stdout
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(stdout.String(), "begin\nline 1\nline 2\n")
+}
+
+func TestAwkToken(t *testing.T) {
+	is := is.New(t)
+
+	command := NewCommand(`mv {} {= awk: toupper($2) "-" NR =}.{=awk:slot=}`, nil, Config{Slots: 2})
+	command.Sequence = 3
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("a b c")})
+	is.NoErr(err)
+	is.Equal(command.Command, "mv 'a b c' B-3.1")
+
+	// Values are never scanned for tokens again
+	command = NewCommand("echo {= awk: $0 =} {}", nil, Config{Slots: 1})
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("$(id){}{#}")})
+	is.NoErr(err)
+	is.Equal(command.Command, "echo '$(id){}{#}' '$(id){}{#}'")
+
+	command = NewCommand("echo {= awk: ( =}", nil, Config{Slots: 1})
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("a")})
+	is.True(err != nil)
+}
+
+func TestTag(t *testing.T) {
+	is := is.New(t)
+
+	command := NewCommand("echo {}", nil, Config{Slots: 4, TagString: "{#}:{/}"})
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("dir/file.txt")})
+	is.NoErr(err)
+	command.tag, err = command.renderTag()
+	is.NoErr(err)
+	is.Equal(command.tag, "1:file.txt\t")
+	is.Equal(command.tagged("a\nb"), "1:file.txt\ta\n1:file.txt\tb")
+
+	command = NewCommand("echo {1} {2}", nil, Config{Slots: 4, Tag: true})
+	err = command.Prepare([]tasks.Task{*tasks.NewTask("a"), *tasks.NewTask("b")})
+	is.NoErr(err)
+	command.tag, err = command.renderTag()
+	is.NoErr(err)
+	is.Equal(command.tag, "a b\t")
+}
+
+func TestResult(t *testing.T) {
+	is := is.New(t)
+
+	command := NewCommand("echo {1} {2} >&2; exit 2", nil, Config{Slots: 2})
+	err := command.Prepare([]tasks.Task{*tasks.NewTask("a b"), *tasks.NewTask("c")})
+	is.NoErr(err)
+	stdout, stderr, err := command.run()
+	is.True(err != nil)
+
+	result := command.Result(stdout, stderr)
+	is.Equal(result.Tasks, []string{"a b", "c"})
+	is.Equal(result.Stderr, "a b c\n")
+	is.Equal(result.ExitCode, 2)
+
+	b, err := json.Marshal(result)
+	is.NoErr(err)
+	var decoded map[string]interface{}
+	is.NoErr(json.Unmarshal(b, &decoded))
+	is.Equal(decoded["command"], "echo 'a b' c >&2; exit 2")
+	is.Equal(decoded["exit_code"], float64(2))
+	_, found := decoded["duration"]
+	is.True(found)
+}
+
+func TestHalt(t *testing.T) {
+	is := is.New(t)
+
+	halt, err := NewHalt("never")
+	is.NoErr(err)
+	is.True(halt == nil)
+	is.True(!halt.Halted())
+
+	halt, err = NewHalt("soon,fail=2")
+	is.NoErr(err)
+	is.True(!halt.Now)
+	is.True(!halt.Record(true, 3))
+	is.True(!halt.Record(false, 0))
+	is.True(halt.Record(true, 5))
+	is.True(halt.Halted())
+	is.Equal(halt.Status(), 5)
+
+	halt, err = NewHalt("soon,success=50%")
+	is.NoErr(err)
+	halt.Total = 4
+	is.True(!halt.Record(false, 0))
+	is.True(halt.Record(false, 0))
+	is.Equal(halt.Status(), 0)
+
+	// Skipped jobs aren't part of the total
+	halt, err = NewHalt("soon,fail=50%")
+	is.NoErr(err)
+	halt.Total = 6
+	halt.Skip()
+	halt.Skip()
+	is.True(!halt.Record(true, 1))
+	is.True(halt.Record(true, 1))
+
+	// Without a total a percentage waits for enough jobs to finish
+	halt, err = NewHalt("soon,fail=10%")
+	is.NoErr(err)
+	is.True(!halt.Record(true, 1))
+	for i := 0; i < percentMinimum-2; i++ {
+		is.True(!halt.Record(false, 0))
+	}
+	is.True(halt.Record(true, 1))
+
+	for _, value := range []string{"later,fail=1", "now", "now,fail", "now,oops=1", "now,fail=0", "soon,fail=200%"} {
+		_, err = NewHalt(value)
+		is.True(err != nil)
+	}
+}
+
+func TestTally(t *testing.T) {
+	is := is.New(t)
+
+	tally := NewTally()
+	for i, code := range []int{0, 1, 2, 0} {
+		command := Command{Command: fmt.Sprintf("exit %d", code), Sequence: int64(i + 1)}
+		err := command.Execute()
+		tally.Record(&command, err)
+	}
+	is.Equal(tally.Done(), int64(4))
+	is.Equal(tally.Failed(), int64(2))
+	is.True(strings.HasPrefix(tally.Summary(), "2 of 4 jobs failed\n  job 2 exit status 1: exit 1\n"))
+
+	for mode, expected := range map[string]int{ExitStatusCount: 2, ExitStatusAny: 1, ExitStatusAll: 0} {
+		status, err := tally.ExitStatus(mode)
+		is.NoErr(err)
+		is.Equal(status, expected)
+	}
+	_, err := tally.ExitStatus("some")
+	is.True(err != nil)
+}
+
+func TestRunner(t *testing.T) {
+	is := is.New(t)
+
+	newTaskListSet := func(items ...string) *tasks.TaskListSet {
+		taskList := tasks.NewTaskList()
+		taskList.Add(items...)
+		taskListSet := tasks.NewTaskListSet()
+		taskListSet.AddTaskList(taskList)
+
+		return &taskListSet
+	}
+
+	// Two runners with their own output used at the same time
+	var stdout1, stdout2 bytes.Buffer
+	runner1 := NewRunner("echo one {}", Config{Slots: 2, KeepOrder: true})
+	runner1.Stdout = &stdout1
+	runner2 := NewRunner("echo two {}; exit {}", Config{Slots: 1})
+	runner2.Stdout = &stdout2
+
+	results1 := runner1.Run(context.Background(), newTaskListSet("1", "2", "3"))
+	results2 := runner2.Run(context.Background(), newTaskListSet("0", "1"))
+
+	var count1, failed2 int
+	for range results1 {
+		count1++
+	}
+	for result := range results2 {
+		if result.ExitCode != 0 {
+			failed2++
+		}
+	}
+	is.Equal(count1, 3)
+	is.Equal(failed2, 1)
+	is.Equal(stdout1.String(), "one 1\none 2\none 3\n")
+	is.True(strings.Contains(stdout2.String(), "two 1\n"))
+
+	// A cancelled context starts no jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner := NewRunner("echo {}", Config{Slots: 1})
+	count := 0
+	for range runner.Run(ctx, newTaskListSet("a", "b")) {
+		count++
+	}
+	is.Equal(count, 0)
+}
+
+// Stream items are used as the first task of each set
+func TestRunnerStream(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("x", "y")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+	stream := make(chan string)
+	taskListSet.SetStream(stream)
+	go func() {
+		defer close(stream)
+		for _, item := range []string{"a", "b", "c"} {
+			stream <- item
+		}
+	}()
+
+	var stdout bytes.Buffer
+	runner := NewRunner("{1}{2}", Config{Slots: 2, KeepOrder: true})
+	runner.Stdout = &stdout
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(stdout.String(), "ax\nby\ncx\n")
+}
+
 // func TestPrepare(t *testing.T) {
 // 	is := is.New(t)
 
@@ -85,3 +555,194 @@ func TestCommand(t *testing.T) {
 // 		t.Log("start", "slot number {%}", "c command", c.Command, false)
 // 	}
 // }
+
+func TestExecutor(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("a b", "c")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	// A fake executor sees the prepared command and supplies the exit code
+	fake := &FakeExecutor{Run: func(ctx context.Context, job Job) int {
+		if strings.HasSuffix(job.Command, " c") {
+			return 3
+		}
+		return 0
+	}}
+	runner := NewRunner("echo {}", Config{Slots: 1, Executor: fake})
+	runner.Stdout = io.Discard
+	var codes []int
+	for result := range runner.Run(context.Background(), &taskListSet) {
+		codes = append(codes, result.ExitCode)
+	}
+	is.Equal(codes, []int{0, 3})
+	is.Equal(fake.Commands(), []string{"echo 'a b'", "echo c"})
+
+	// A timed out fake job is signalled
+	timeout, err := NewTimeout("10ms", time.Millisecond)
+	is.NoErr(err)
+	fake = &FakeExecutor{Run: func(ctx context.Context, job Job) int {
+		<-ctx.Done()
+		return 0
+	}}
+	c := NewCommand("sleep", nil, Config{Slots: 1, Timeout: timeout, Executor: fake, JSON: true})
+	c.out = new(output)
+	_, _, err = c.run()
+	is.True(err != nil)
+	is.True(c.TimedOut)
+	is.Equal(c.Signal, int(syscall.SIGTERM))
+
+	// The direct executor passes each quoted word as one argument without a shell
+	c = NewCommand(`printf %s|%s {} $HOME`, nil, Config{Slots: 1, Executor: NewDirectExecutor()})
+	err = c.Prepare([]tasks.Task{*tasks.NewTask("a b")})
+	is.NoErr(err)
+	outStr, _, err := c.run()
+	is.NoErr(err)
+	is.Equal(outStr, "a b|$HOME")
+
+	// Commands run without a shell can be longer than a shell's single argument
+	is.Equal(MaxLength(NewShellExecutor("")), MaxCommandLength)
+	is.Equal(MaxLength(new(FakeExecutor)), MaxCommandLength)
+	is.True(MaxLength(NewDirectExecutor()) > 0 && MaxLength(NewDirectExecutor()) < ArgMax)
+}
+
+func TestWorkers(t *testing.T) {
+	is := is.New(t)
+
+	taskList := tasks.NewTaskList()
+	taskList.Add("1", "2", "3", "4")
+	taskListSet := tasks.NewTaskListSet()
+	taskListSet.AddTaskList(taskList)
+
+	// Workers are started once for each slot with {%} as the worker's number and answer every job
+	worker := `while read l; do echo "{%} $$ $l"; done`
+	executor := NewWorkerExecutor(NewShellExecutor(DefaultShell), worker, 2, "")
+	runner := NewRunner(worker, Config{Slots: 2, StdIn: true, Executor: executor})
+	runner.Stdout = io.Discard
+	pids := make(map[string]string)
+	for result := range runner.Run(context.Background(), &taskListSet) {
+		fields := strings.Fields(result.Stdout)
+		is.Equal(len(fields), 3)
+		is.Equal(fields[0], fmt.Sprint(result.Slot))
+		is.Equal(result.Command, fmt.Sprintf(`while read l; do echo "%d $$ $l"; done`, result.Slot))
+		is.Equal(fields[2], result.Tasks[0])
+		if id, ok := pids[fields[1]]; ok {
+			is.Equal(id, fields[0])
+		}
+		pids[fields[1]] = fields[0]
+	}
+	is.True(len(pids) <= 2)
+	executor.Close()
+
+	// Jobs 1 and 3 both have slot 1 but job 3 doesn't wait for job 1 as job 2's worker is free
+	sleeps := tasks.NewTaskList()
+	sleeps.Add("0.5", "0", "0.1")
+	sleepSet := tasks.NewTaskListSet()
+	sleepSet.AddTaskList(sleeps)
+	worker = `while read l; do sleep $l; echo $l; done`
+	executor = NewWorkerExecutor(NewShellExecutor(DefaultShell), worker, 2, "")
+	runner = NewRunner(worker, Config{Slots: 2, StdIn: true, Executor: executor})
+	runner.Stdout = io.Discard
+	var finished []string
+	slots := make(map[int64]int64)
+	for result := range runner.Run(context.Background(), &sleepSet) {
+		finished = append(finished, result.Tasks[0])
+		slots[result.Sequence] = result.Slot
+	}
+	is.Equal(finished, []string{"0", "0.1", "0.5"})
+	// Job 3 reports the slot of the worker that ran it, which is job 2's
+	is.Equal(slots[3], slots[2])
+	is.True(slots[3] != slots[1])
+	executor.Close()
+
+	// A worker that exits fails the job and is started again for the next one
+	worker = `read l; test $l = 1 && echo one`
+	executor = NewWorkerExecutor(NewShellExecutor(DefaultShell), worker, 1, "")
+	runner = NewRunner(worker, Config{Slots: 1, StdIn: true, Executor: executor})
+	runner.Stdout = io.Discard
+	runner.Stderr = io.Discard
+	var failed int
+	for result := range runner.Run(context.Background(), &taskListSet) {
+		if result.ExitCode != 0 {
+			failed++
+		}
+	}
+	is.Equal(failed, 3)
+}
+
+// Piped chunks are sent to stdin and their output is written as it is
+func TestPipe(t *testing.T) {
+	is := is.New(t)
+
+	taskListSet := tasks.NewTaskListSet()
+	stream := make(chan string, 2)
+	stream <- "  a\nb\n"
+	stream <- "c\n\n"
+	close(stream)
+	taskListSet.SetStream(stream)
+
+	var stdout bytes.Buffer
+	runner := NewRunner("cat", Config{Slots: 2, StdIn: true, Pipe: true, KeepOrder: true})
+	runner.Stdout = &stdout
+	for range runner.Run(context.Background(), &taskListSet) {
+	}
+	is.Equal(stdout.String(), "  a\nb\nc\n\n")
+}
+
+func TestBatch(t *testing.T) {
+	is := is.New(t)
+
+	newTaskListSet := func(items ...string) *tasks.TaskListSet {
+		taskList := tasks.NewTaskList()
+		taskList.Add(items...)
+		taskListSet := tasks.NewTaskListSet()
+		taskListSet.AddTaskList(taskList)
+
+		return &taskListSet
+	}
+
+	// {} expands to each quoted item and {#} counts batches
+	fake := new(FakeExecutor)
+	runner := NewRunner("rm {#} {}", Config{Slots: 1, MaxArgs: 2, Executor: fake})
+	runner.Stdout = io.Discard
+	for range runner.Run(context.Background(), newTaskListSet("a", "b c", "d.txt")) {
+	}
+	is.Equal(fake.Commands(), []string{"rm 1 a 'b c'", "rm 2 d.txt"})
+
+	// Batches fit within a length
+	fake = new(FakeExecutor)
+	runner = NewRunner("echo {.}", Config{Slots: 1, MaxLength: 20, Executor: fake})
+	runner.Stdout = io.Discard
+	for range runner.Run(context.Background(), newTaskListSet("1.a", "2.a", "3.a", "4.a", "5.a")) {
+	}
+	is.Equal(fake.Commands(), []string{"echo 1 2 3", "echo 4 5"})
+
+	// Halt percentages are of the number of batches
+	var items []string
+	for i := 0; i < 40; i++ {
+		items = append(items, "x")
+	}
+	fail := func(ctx context.Context, job Job) int { return 1 }
+	halt, err := NewHalt("soon,fail=50%")
+	is.NoErr(err)
+	fake = &FakeExecutor{Run: fail}
+	runner = NewRunner("false {}", Config{Slots: 1, MaxArgs: 4, Halt: halt, Executor: fake})
+	runner.Stdout = io.Discard
+	runner.Stderr = io.Discard
+	for range runner.Run(context.Background(), newTaskListSet(items...)) {
+	}
+	is.Equal(len(fake.Commands()), 5)
+
+	// and wait for enough batches to finish when batches fit within a length
+	halt, err = NewHalt("soon,fail=50%")
+	is.NoErr(err)
+	fake = &FakeExecutor{Run: fail}
+	runner = NewRunner("false {}", Config{Slots: 1, MaxLength: 10, Halt: halt, Executor: fake})
+	runner.Stdout = io.Discard
+	runner.Stderr = io.Discard
+	for range runner.Run(context.Background(), newTaskListSet(items...)) {
+	}
+	is.Equal(len(fake.Commands()), percentMinimum)
+}
@@ -0,0 +1,298 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/imarsman/concur/cmd/parse"
+)
+
+// DefaultShell shell used to run commands if no other executor is set
+const DefaultShell = "bash"
+
+// Job what an executor needs to start a prepared command
+type Job struct {
+	Command  string    // command with placeholders replaced
+	Sequence int64     // sequence number of the job
+	Slot     int64     // slot the job runs in
+	Stdin    io.Reader // input for the job, nil for none
+	Stdout   io.Writer
+	Stderr   io.Writer
+}
+
+// Process a started job
+type Process interface {
+	Wait() error                     // wait for the job to finish
+	Signal(sig syscall.Signal) error // signal the job and anything it started
+}
+
+// Executor start the process for a job
+type Executor interface {
+	Start(job Job) (Process, error)
+	Describe(command string) string // what would be run, for a dry run
+}
+
+// Placer a process that may run in another slot with another command than its job, such as a job sent to whichever
+// worker is free. Placement is only called once the process has finished.
+type Placer interface {
+	Placement() (slot int64, command string)
+}
+
+// ExitError an error for a job that did not exit cleanly
+type ExitError struct {
+	Code   int
+	Signal syscall.Signal
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("signal: %v", e.Signal)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode get the exit code
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// exitStatus get the exit code and signal for the error from running a job
+// An error that is not from the job exiting, such as a command that can't be found, gives an exit code of -1.
+func exitStatus(err error) (code int, signal int) {
+	if err == nil {
+		return
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			signal = int(status.Signal())
+		}
+		return
+	}
+	var jobErr *ExitError
+	if errors.As(err, &jobErr) {
+		return jobErr.Code, int(jobErr.Signal)
+	}
+
+	return -1, 0
+}
+
+// osProcess a job running as an operating system process in its own process group
+type osProcess struct {
+	cmd *exec.Cmd
+}
+
+// startProcess start a process for a job
+func startProcess(cmd *exec.Cmd, job Job) (Process, error) {
+	cmd.Stdin = job.Stdin
+	cmd.Stdout = job.Stdout
+	cmd.Stderr = job.Stderr
+	// Run each job in its own process group so the job and anything it starts can be signalled together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &osProcess{cmd: cmd}, nil
+}
+
+// Wait wait for the process to finish
+func (p *osProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+// Signal signal the process group
+func (p *osProcess) Signal(sig syscall.Signal) error {
+	// A negative pid signals the whole process group
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// ShellExecutor run commands with a shell such as bash, sh, zsh or dash
+type ShellExecutor struct {
+	Shell string // path or name of the shell
+}
+
+// NewShellExecutor make a new shell executor. An empty shell means bash.
+func NewShellExecutor(shell string) *ShellExecutor {
+	if shell == "" {
+		shell = DefaultShell
+	}
+
+	return &ShellExecutor{Shell: shell}
+}
+
+// Start run the command with the shell's -c option
+func (e *ShellExecutor) Start(job Job) (Process, error) {
+	return startProcess(exec.Command(e.Shell, "-c", job.Command), job)
+}
+
+// Describe show the shell and command
+func (e *ShellExecutor) Describe(command string) string {
+	return exec.Command(e.Shell, "-c", command).String()
+}
+
+// MaxLength the longest command the shell can be given
+func (e *ShellExecutor) MaxLength() int {
+	return MaxCommandLength
+}
+
+// DirectExecutor run commands without a shell
+// The prepared command is split into words with shell quoting rules and the first word is run with the rest as its
+// arguments. Nothing is expanded, so variables, globs, pipes and redirection are passed through as text.
+type DirectExecutor struct{}
+
+// NewDirectExecutor make a new executor that runs commands without a shell
+func NewDirectExecutor() *DirectExecutor {
+	return &DirectExecutor{}
+}
+
+// Start split the command into arguments and run it
+func (e *DirectExecutor) Start(job Job) (Process, error) {
+	args, err := parse.Words(job.Command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command to run in %q", job.Command)
+	}
+
+	return startProcess(exec.Command(args[0], args[1:]...), job)
+}
+
+// Describe show the arguments the command would be run with, one quoted argument per word
+func (e *DirectExecutor) Describe(command string) string {
+	args, err := parse.Words(command)
+	if err != nil {
+		return fmt.Sprintf("%s (%v)", command, err)
+	}
+	for i, arg := range args {
+		args[i] = fmt.Sprintf("%q", arg)
+	}
+
+	return strings.Join(args, " ")
+}
+
+// MaxLength the longest command that fits in the arguments and environment of a new process
+// Each argument also takes a pointer, so only half of what is left after the environment is used for text.
+func (e *DirectExecutor) MaxLength() int {
+	length := ArgMax
+	for _, v := range os.Environ() {
+		length -= len(v) + 1
+	}
+
+	return length / 2
+}
+
+// ArgMax size of the arguments and environment of a new process. This is the limit on macOS, Linux usually allows
+// more.
+const ArgMax = 1 << 20
+
+// Limiter an executor that limits the length of the commands it can run
+type Limiter interface {
+	MaxLength() int
+}
+
+// MaxLength get the longest command an executor can run when batching inputs to fit, which is MaxCommandLength for
+// executors that don't set their own limit
+func MaxLength(executor Executor) int {
+	if limiter, ok := executor.(Limiter); ok {
+		return limiter.MaxLength()
+	}
+
+	return MaxCommandLength
+}
+
+// FakeExecutor an in memory executor for tests
+// Each job calls Run, which returns the job's exit code. The context passed to Run is cancelled if the job is
+// signalled. Without a Run function the command is written to stdout and the job succeeds.
+type FakeExecutor struct {
+	Run      func(ctx context.Context, job Job) int
+	mu       sync.Mutex
+	commands []string
+}
+
+// Start start a goroutine for the job
+func (e *FakeExecutor) Start(job Job) (Process, error) {
+	e.mu.Lock()
+	e.commands = append(e.commands, job.Command)
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &fakeProcess{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(p.done)
+		defer cancel()
+		if e.Run == nil {
+			io.WriteString(job.Stdout, job.Command+"\n")
+			return
+		}
+		p.code = e.Run(ctx, job)
+	}()
+
+	return p, nil
+}
+
+// Describe show the command
+func (e *FakeExecutor) Describe(command string) string {
+	return command
+}
+
+// Commands get the commands started so far
+func (e *FakeExecutor) Commands() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.commands...)
+}
+
+// fakeProcess a job started by a fake executor
+type fakeProcess struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	code   int
+	signal syscall.Signal
+}
+
+// Wait wait for the job's Run function to return
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signal != 0 {
+		return &ExitError{Code: -1, Signal: p.signal}
+	}
+	if p.code != 0 {
+		return &ExitError{Code: p.code}
+	}
+
+	return nil
+}
+
+// Signal cancel the job's context
+func (p *fakeProcess) Signal(sig syscall.Signal) error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	p.mu.Lock()
+	if p.signal == 0 {
+		p.signal = sig
+	}
+	p.mu.Unlock()
+	p.cancel()
+
+	return nil
+}
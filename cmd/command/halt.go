@@ -0,0 +1,159 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// percentMinimum jobs that must finish before a percentage is checked when the total number of jobs isn't known
+const percentMinimum = 10
+
+// killGrace time to wait after SIGTERM before sending SIGKILL to running jobs when halting now
+const killGrace = time.Second
+
+// Halt a policy for stopping a run early, such as now,fail=1 or soon,fail=10% or now,success=1.
+// Halting soon stops new jobs from starting and waits for running jobs. Halting now also kills running jobs.
+type Halt struct {
+	Now     bool    // kill running jobs
+	Success bool    // count jobs that succeed rather than jobs that fail
+	Count   int     // number of counted jobs needed to halt
+	Percent float64 // percentage of counted jobs needed to halt, used if Count is zero
+	Total   int64   // total number of jobs if known, used for percentages
+	mu      sync.Mutex
+	skipped int64
+	done    int64
+	matched int64
+	status  int
+	halted  int32
+}
+
+// NewHalt make a new halt policy. A value of never or an empty value results in no policy.
+func NewHalt(value string) (halt *Halt, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "never" {
+		return
+	}
+
+	parts := strings.SplitN(value, ",", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("invalid halt %s, expected when,condition such as now,fail=1", value)
+		return
+	}
+	halt = &Halt{}
+	switch parts[0] {
+	case "now":
+		halt.Now = true
+	case "soon":
+	default:
+		err = fmt.Errorf("invalid halt %s, expected now or soon", value)
+		return
+	}
+
+	condition := strings.SplitN(parts[1], "=", 2)
+	if len(condition) != 2 {
+		err = fmt.Errorf("invalid halt %s, expected a condition such as fail=1", value)
+		return
+	}
+	switch condition[0] {
+	case "fail":
+	case "success":
+		halt.Success = true
+	default:
+		err = fmt.Errorf("invalid halt %s, expected fail or success", value)
+		return
+	}
+
+	amount := condition[1]
+	if strings.HasSuffix(amount, "%") {
+		halt.Percent, err = strconv.ParseFloat(strings.TrimSuffix(amount, "%"), 64)
+		if err == nil && (halt.Percent <= 0 || halt.Percent > 100) {
+			err = fmt.Errorf("halt percentage %s out of range", amount)
+		}
+	} else {
+		halt.Count, err = strconv.Atoi(amount)
+		if err == nil && halt.Count <= 0 {
+			err = fmt.Errorf("halt count %s must be greater than zero", amount)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid halt %s: %v", value, err)
+	}
+
+	return
+}
+
+// Record record the outcome of a finished job, returning true if this job caused the run to halt
+func (h *Halt) Record(failed bool, exitCode int) bool {
+	if h == nil || h.Halted() {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.done++
+	if failed == h.Success {
+		return false
+	}
+	h.matched++
+
+	if h.Count > 0 {
+		if h.matched < int64(h.Count) {
+			return false
+		}
+	} else {
+		// Without a total the percentage is of the jobs finished so far, once enough have finished for it to mean
+		// something
+		total := h.Total - h.skipped
+		if h.Total == 0 {
+			if h.done < percentMinimum {
+				return false
+			}
+			total = h.done
+		}
+		if total < 1 || float64(h.matched)*100/float64(total) < h.Percent {
+			return false
+		}
+	}
+
+	if failed {
+		h.status = exitCode
+		if h.status <= 0 {
+			h.status = 1
+		}
+	}
+	atomic.StoreInt32(&h.halted, 1)
+
+	return true
+}
+
+// Skip record a job that was skipped, such as one finished in an earlier run, so it isn't part of the total
+func (h *Halt) Skip() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.skipped++
+}
+
+// Halted check whether the run has halted
+func (h *Halt) Halted() bool {
+	if h == nil {
+		return false
+	}
+
+	return atomic.LoadInt32(&h.halted) == 1
+}
+
+// Status get the exit status for a halted run
+func (h *Halt) Status() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.status
+}
@@ -0,0 +1,152 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// output output collected for a job while it runs
+type output struct {
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// Print send to output
+// While a job is executing output is collected and written when the job finishes.
+func (c *Command) Print(file *os.File, str string) {
+	str = c.tagged(strings.TrimSpace(str))
+	if c.out != nil {
+		if file == os.Stderr {
+			fmt.Fprintln(&c.out.stderr, str)
+		} else {
+			fmt.Fprintln(&c.out.stdout, str)
+		}
+		return
+	}
+
+	c.runner.write(file == os.Stderr, []byte(fmt.Sprintln(str)))
+}
+
+// printRaw send output exactly as it is, without trimming or tagging
+// Jobs fed piped input can write binary output such as compressed data.
+func (c *Command) printRaw(file *os.File, str string) {
+	if c.out != nil {
+		if file == os.Stderr {
+			c.out.stderr.WriteString(str)
+		} else {
+			c.out.stdout.WriteString(str)
+		}
+		return
+	}
+
+	c.runner.write(file == os.Stderr, []byte(str))
+}
+
+// flush write the output collected for a job
+// With keep order set the output is handed to the reorder buffer to be written in sequence order.
+func (c *Command) flush() {
+	if c.out == nil {
+		return
+	}
+	out := c.out
+	c.out = nil
+
+	if c.Config.Order != nil {
+		err := c.Config.Order.Add(c.GetSequence(), out.stdout.Bytes(), out.stderr.Bytes())
+		if err != nil {
+			c.Print(os.Stderr, fmt.Sprintf("keep order: %v", err))
+		}
+		return
+	}
+
+	c.runner.write(false, out.stdout.Bytes())
+	c.runner.write(true, out.stderr.Bytes())
+}
+
+// renderTag get the prefix for output lines
+// The tag is the job's input unless there is a tag template.
+func (c *Command) renderTag() (tag string, err error) {
+	if !c.Config.Tag && c.Config.TagString == "" {
+		return
+	}
+	if c.Config.TagString == "" {
+		tag = c.Input + "\t"
+		return
+	}
+	if len(c.Tasks) == 0 {
+		tag = c.Config.TagString + "\t"
+		return
+	}
+
+	tc := c.Copy()
+	tc.Command = c.Config.TagString
+	tc.Template = ""
+	tc.out = nil
+	tc.raw = true
+	err = tc.Prepare(c.Tasks)
+	if err != nil {
+		return
+	}
+	tag = tc.Command + "\t"
+
+	return
+}
+
+// tagged prefix each line with the job's tag
+func (c *Command) tagged(str string) string {
+	if c.tag == "" {
+		return str
+	}
+	lines := strings.Split(str, "\n")
+	for i := range lines {
+		lines[i] = c.tag + lines[i]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// lineWriter write complete lines of a running job's output as they are produced
+type lineWriter struct {
+	c       *Command
+	stderr  bool
+	partial []byte
+	written int64
+}
+
+// newLineWriter make a new line writer for a command's stdout or stderr
+func newLineWriter(c *Command, stderr bool) *lineWriter {
+	lw := lineWriter{c: c, stderr: stderr}
+
+	return &lw
+}
+
+// Write write each complete line and keep any partial line until it is completed
+func (lw *lineWriter) Write(p []byte) (n int, err error) {
+	lw.written += int64(len(p))
+	lw.partial = append(lw.partial, p...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lw.line(string(lw.partial[:i]))
+		lw.partial = lw.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close write any remaining partial line
+func (lw *lineWriter) Close() {
+	if len(lw.partial) > 0 {
+		lw.line(string(lw.partial))
+		lw.partial = nil
+	}
+}
+
+// line write a line
+func (lw *lineWriter) line(str string) {
+	lw.c.runner.write(lw.stderr, []byte(fmt.Sprintln(lw.c.tagged(str))))
+}
@@ -0,0 +1,95 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Result the outcome of a finished job
+type Result struct {
+	Sequence int64         `json:"sequence"`
+	Slot     int64         `json:"slot"`
+	Tasks    []string      `json:"tasks"`
+	Command  string        `json:"command"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	ExitCode int           `json:"exit_code"`
+	Signal   int           `json:"signal"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"-"`
+	TimedOut bool          `json:"timed_out"`
+	Retried  bool          `json:"retried"`
+	Attempts int           `json:"attempts"`
+}
+
+// MarshalJSON encode a result with its duration in seconds
+// Characters such as < and > are left as is since they are common in commands.
+func (r Result) MarshalJSON() (b []byte, err error) {
+	type result Result
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(struct {
+		result
+		Duration float64 `json:"duration"`
+	}{result(r), r.Duration.Seconds()})
+	if err != nil {
+		return
+	}
+	b = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	return
+}
+
+// Result get the result of the job's final attempt
+func (c *Command) Result(stdout, stderr string) (result Result) {
+	result = Result{
+		Sequence: c.GetSequence(),
+		Slot:     c.GetSlotNumber(),
+		Tasks:    []string{},
+		Command:  c.Command,
+		Stdout:   stdout,
+		Stderr:   stderr,
+		ExitCode: c.ExitCode,
+		Signal:   c.Signal,
+		Start:    c.Start,
+		Duration: c.Duration,
+		TimedOut: c.TimedOut,
+		Retried:  c.Attempt > 1,
+		Attempts: c.Attempt,
+	}
+	for _, t := range c.Tasks {
+		result.Tasks = append(result.Tasks, t.Task)
+	}
+
+	return
+}
+
+// printJSON write the result of the job as a single line JSON object
+// Output is kept as produced apart from being run through awk if there is an awk script.
+func (c *Command) printJSON(outStr, errStr string) (err error) {
+	if c.Config.Awk != nil {
+		outStr, err = c.Config.Awk.Execute(outStr, "attempt", strconv.Itoa(c.Attempt))
+		if err != nil {
+			errStr += fmt.Sprintf("%v\n", err)
+		}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(c.Result(outStr, errStr))
+	if err != nil {
+		return
+	}
+	if c.out != nil {
+		c.out.stdout.Write(buf.Bytes())
+		return
+	}
+	c.runner.write(false, buf.Bytes())
+
+	return
+}
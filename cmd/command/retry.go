@@ -0,0 +1,44 @@
+package command
+
+import (
+	"time"
+)
+
+// Retry policy for rerunning failed jobs
+type Retry struct {
+	Retries   int           // number of times to rerun a failed job
+	Delay     time.Duration // delay before each retry
+	Backoff   bool          // double the delay after each retry
+	ExitCodes []int         // only retry jobs exiting with one of these codes if not empty
+}
+
+// shouldRetry check whether a job that failed on the given attempt should be run again
+func (r *Retry) shouldRetry(attempt, exitCode int) bool {
+	if !r.mayRetry(attempt) {
+		return false
+	}
+	if len(r.ExitCodes) == 0 {
+		return true
+	}
+	for _, code := range r.ExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayRetry check whether a job could be run again if it fails on the given attempt
+func (r *Retry) mayRetry(attempt int) bool {
+	return r != nil && attempt <= r.Retries
+}
+
+// delay get the delay to wait before the retry following the given attempt
+func (r *Retry) delay(attempt int) time.Duration {
+	if !r.Backoff {
+		return r.Delay
+	}
+
+	return r.Delay * time.Duration(int64(1)<<uint(attempt-1))
+}
@@ -0,0 +1,425 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"github.com/imarsman/concur/cmd/awk"
+	"github.com/imarsman/concur/cmd/parse"
+	"github.com/imarsman/concur/cmd/reorder"
+	"github.com/imarsman/concur/cmd/tasks"
+	"golang.org/x/sync/semaphore"
+)
+
+// MaxCommandLength longest command to make when batching inputs to fit a shell. Linux limits a single argument, such
+// as the command passed to a shell with -c, to 128KiB. Some room is left for the shell and its other arguments.
+const MaxCommandLength = 128<<10 - 4<<10
+
+// Runner run a command against the items in a task list set
+// Each runner has its own slots, output and running jobs so several runners can be used independently in one process.
+// A runner runs one task list set at a time.
+type Runner struct {
+	Command string    // command with placeholders
+	Config  Config    // parameters for each job
+	Stdout  io.Writer // where job output is written
+	Stderr  io.Writer // where job error output is written
+	sem     *semaphore.Weighted
+	ctx     context.Context
+	printMu sync.Mutex
+	procMu  sync.Mutex
+	procs   map[Process]bool
+	results chan Result
+}
+
+// NewRunner make a new runner that writes output to os.Stdout and os.Stderr
+func NewRunner(command string, config Config) *Runner {
+	r := Runner{
+		Command: command,
+		Config:  config,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		procs:   make(map[Process]bool),
+	}
+
+	return &r
+}
+
+// Run run the command for each set of tasks from the task list set. If the set has a stream its items are used as the
+// first task of each set, otherwise there are as many sets as the longest list has items.
+// The returned channel receives the result of each finished job and is closed once all jobs are done. The channel must
+// be drained. Cancelling the context stops new jobs from starting and lets running jobs finish.
+func (r *Runner) Run(ctx context.Context, taskListSet *tasks.TaskListSet) <-chan Result {
+	r.results = make(chan Result)
+	r.ctx = ctx
+
+	go func() {
+		defer close(r.results)
+		r.run(ctx, taskListSet)
+	}()
+
+	return r.results
+}
+
+// run start jobs and wait for them to finish
+func (r *Runner) run(ctx context.Context, taskListSet *tasks.TaskListSet) {
+	slots := r.Config.Slots
+	// Avoid locking things up
+	if slots < 1 {
+		slots = 1
+	}
+	r.Config.Slots = slots
+	r.sem = semaphore.NewWeighted(slots)
+
+	if r.Config.Expressions == nil {
+		r.Config.Expressions = awk.NewExpressions()
+	}
+	if r.Config.KeepOrder && r.Config.Order == nil {
+		r.Config.Order = reorder.New(1, reorder.DefaultLimit, r.Stdout, r.Stderr)
+	}
+
+	c := NewCommand(r.Command, taskListSet, r.Config)
+	c.runner = r
+
+	var wg = new(sync.WaitGroup)
+
+	// start start a job for a set of tasks, returning false if no more jobs should be started
+	var stopped bool
+	var start = func(taskSet []tasks.Task) bool {
+		if r.Config.Skip != nil && r.Config.Skip(c.GetSequence()) {
+			r.Config.Order.Skip(c.GetSequence())
+			r.Config.Halt.Skip()
+			c.SequenceIncr()
+			return true
+		}
+
+		wg.Add(1)
+		err := r.runCommand(ctx, c.Copy(), taskSet, wg)
+		if errors.Is(err, context.Canceled) {
+			stopped = true
+			return false
+		}
+		if err != nil {
+			r.write(true, []byte(fmt.Sprintln(err)))
+			stopped = true
+			return false
+		}
+		c.SequenceIncr()
+
+		return true
+	}
+
+	// Inputs batched for the next job when running with several inputs per job
+	var batch [][]tasks.Task
+	var batchLength int
+	var tokens = len(parse.REAllTokens.FindAllString(r.Command, -1))
+	if tokens == 0 {
+		tokens = 1
+	}
+	batching := r.Config.MaxArgs > 1 || r.Config.MaxLength > 0
+
+	// flush start a job for the batched task sets
+	var flush = func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		taskSet := tasks.Merge(batch)
+		batch = nil
+		batchLength = 0
+
+		return start(taskSet)
+	}
+
+	// add batch a set of tasks, starting a job once a batch is full
+	var add = func(taskSet []tasks.Task) bool {
+		if !batching {
+			return start(taskSet)
+		}
+		// Each token expands to every quoted item of a task
+		var length int
+		for _, t := range taskSet {
+			length += (len(shellescape.Quote(t.Task)) + 1) * tokens
+		}
+		if r.Config.MaxLength > 0 && len(batch) > 0 && len(r.Command)+batchLength+length > r.Config.MaxLength {
+			if !flush() {
+				return false
+			}
+		}
+		batch = append(batch, taskSet)
+		batchLength += length
+		if r.Config.MaxArgs > 0 && len(batch) >= r.Config.MaxArgs {
+			return flush()
+		}
+
+		return true
+	}
+
+	// header use the first set of tasks as the names of fields if the names come from the input
+	var header = func(taskSet []tasks.Task) bool {
+		if !r.Config.HeaderLine || c.Config.Header != nil {
+			return false
+		}
+		names := []string{}
+		for _, field := range c.fields(taskSet) {
+			names = append(names, strings.TrimSpace(field.Task))
+		}
+		c.Config.Header = names
+		r.Config.Header = names
+
+		return true
+	}
+
+	// matches check whether the input line of a set of tasks matches if there is a regular expression to match
+	var matches = func(taskSet []tasks.Task) bool {
+		if r.Config.Match == nil || r.Config.Match.MatchString(taskSet[0].Task) {
+			return true
+		}
+		if r.Config.MatchStrict {
+			r.write(true, []byte(fmt.Sprintf("no match for input: %s\n", taskSet[0].Task)))
+			c.Config.Tally.Unmatched()
+		}
+
+		return false
+	}
+
+	if taskListSet.Stream != nil {
+		foundArgumentList := len(taskListSet.TaskLists) > 0
+	streamLoop:
+		for {
+			var item string
+			var ok bool
+			select {
+			case <-ctx.Done():
+				break streamLoop
+			case item, ok = <-taskListSet.Stream:
+				if !ok {
+					break streamLoop
+				}
+			}
+			if r.Config.Halt.Halted() {
+				break
+			}
+			// Chunks of piped input are sent exactly as they were read
+			if !r.Config.Pipe {
+				item = strings.TrimSpace(item)
+			}
+			// If we have just stdin and no -a lists handle them as they come.
+			if len(item) == 0 {
+				// Print out empty lines if that has been flagged
+				if r.Config.PrintEmpty {
+					c.Print(os.Stdout, "")
+				}
+				continue
+			}
+			var task = tasks.NewTask(item)
+			var taskSet []tasks.Task
+			taskSet = append(taskSet, *task)
+			if header(taskSet) || !matches(taskSet) {
+				continue
+			}
+
+			// With a product each item is combined with every set from the lists
+			if foundArgumentList && taskListSet.Combine == tasks.CombineProduct {
+				for i := 0; i < taskListSet.Count(); i++ {
+					if !add(append(taskSet, taskListSet.Set(i)...)) {
+						break streamLoop
+					}
+				}
+				continue
+			}
+			if foundArgumentList {
+				newTasks, err := taskListSet.NextAll()
+				if errors.Is(err, tasks.ErrExhausted) {
+					break
+				}
+				taskSet = append(taskSet, newTasks...)
+			}
+			if !add(taskSet) {
+				break
+			}
+		}
+	} else {
+		// Run through as many sets as the lists make
+		if r.Config.Halt != nil {
+			r.Config.Halt.Total = int64(taskListSet.Count())
+			if r.Config.MaxArgs > 1 {
+				r.Config.Halt.Total = (r.Config.Halt.Total + int64(r.Config.MaxArgs) - 1) / int64(r.Config.MaxArgs)
+			}
+			// The number of batches that fit within a length isn't known until they are made
+			if r.Config.MaxLength > 0 {
+				r.Config.Halt.Total = 0
+			}
+		}
+		for i := 0; i < taskListSet.Count(); i++ {
+			if r.Config.Halt.Halted() || ctx.Err() != nil {
+				break
+			}
+			taskSet, _ := taskListSet.NextAll()
+			if header(taskSet) || !matches(taskSet) {
+				continue
+			}
+
+			empty := true
+			for _, t := range taskSet {
+				if len(strings.TrimSpace(t.Task)) > 0 {
+					empty = false
+					continue
+				}
+			}
+			if empty {
+				if r.Config.PrintEmpty {
+					c.Print(os.Stdout, "")
+				}
+				continue
+			}
+			if !add(taskSet) {
+				break
+			}
+		}
+	}
+	// Run what is left of a batch unless the run was stopped
+	if !stopped && !r.Config.Halt.Halted() && ctx.Err() == nil {
+		flush()
+	}
+
+	wg.Wait()
+	r.Config.Order.Flush()
+	// Let long lived processes such as workers exit
+	if closer, ok := r.Config.Executor.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// runCommand prepare a command and run it once a slot is free
+// If the context is cancelled while waiting for a slot the command is not run and the context's error is returned.
+func (r *Runner) runCommand(ctx context.Context, c Command, taskSet []tasks.Task, wg *sync.WaitGroup) (err error) {
+	err = c.Prepare(taskSet)
+	if err != nil {
+		// A job that can't be prepared counts as failed
+		c.Config.Tally.Record(&c, err)
+		c.Config.Order.Skip(c.GetSequence())
+		wg.Done()
+		return
+	}
+
+	// Acquire weight of one from semaphore
+	err = r.sem.Acquire(ctx, 1)
+	if err != nil {
+		c.Config.Order.Skip(c.GetSequence())
+		wg.Done()
+		return
+	}
+
+	// The run may have halted or been interrupted while waiting for a slot
+	if c.Config.Halt.Halted() || ctx.Err() != nil {
+		err = ctx.Err()
+		r.sem.Release(1)
+		c.Config.Order.Skip(c.GetSequence())
+		wg.Done()
+		return
+	}
+
+	// Run and release waitgroup for overall processing and semaphore for concurrency
+	// Failures are counted by the tally as part of execution.
+	var run = func() {
+		defer wg.Done()
+		defer r.sem.Release(1)
+		c.Execute()
+	}
+
+	// Run as go process. Will run ordered if semaphore count set to 1
+	go run()
+
+	return
+}
+
+// pause give up a job's slot while it waits to be retried and take a slot again once the delay is over
+// Other jobs can use the slot in the meantime. False is returned if the job shouldn't be retried as the run was
+// interrupted or halted while waiting.
+func (r *Runner) pause(delay time.Duration, halt *Halt) bool {
+	if r == nil || r.sem == nil {
+		time.Sleep(delay)
+		return !halt.Halted()
+	}
+	r.sem.Release(1)
+	time.Sleep(delay)
+	// The slot is released when the job finishes so one is always taken again
+	r.sem.Acquire(context.Background(), 1)
+
+	return r.ctx.Err() == nil && !halt.Halted()
+}
+
+// send send the result of a finished job to the channel returned by Run
+func (r *Runner) send(result Result) {
+	if r == nil || r.results == nil {
+		return
+	}
+	r.results <- result
+}
+
+// write write output without interleaving it with other output
+// Without a runner output goes to os.Stdout and os.Stderr.
+func (r *Runner) write(stderr bool, b []byte) {
+	if r == nil {
+		if stderr {
+			os.Stderr.Write(b)
+		} else {
+			os.Stdout.Write(b)
+		}
+		return
+	}
+
+	r.printMu.Lock()
+	defer r.printMu.Unlock()
+	if stderr {
+		r.Stderr.Write(b)
+	} else {
+		r.Stdout.Write(b)
+	}
+}
+
+// track add a running job's process
+func (r *Runner) track(proc Process) {
+	if r == nil {
+		return
+	}
+	r.procMu.Lock()
+	defer r.procMu.Unlock()
+	r.procs[proc] = true
+}
+
+// untrack remove a finished job's process
+func (r *Runner) untrack(proc Process) {
+	if r == nil {
+		return
+	}
+	r.procMu.Lock()
+	defer r.procMu.Unlock()
+	delete(r.procs, proc)
+}
+
+// Signal send a signal to every running job
+func (r *Runner) Signal(sig syscall.Signal) {
+	r.procMu.Lock()
+	defer r.procMu.Unlock()
+	for proc := range r.procs {
+		proc.Signal(sig)
+	}
+}
+
+// killRunning terminate all running jobs, killing any still running after a grace period
+func (r *Runner) killRunning() {
+	if r == nil {
+		return
+	}
+	r.Signal(syscall.SIGTERM)
+	time.Sleep(killGrace)
+	r.Signal(syscall.SIGKILL)
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exit status modes
+const (
+	ExitStatusCount = "count" // the number of failed jobs up to MaxExitStatus
+	ExitStatusAny   = "any"   // 1 if any job failed
+	ExitStatusAll   = "all"   // 1 if every job failed
+)
+
+// MaxExitStatus largest exit status used for a count of failed jobs
+const MaxExitStatus = 101
+
+// maxSummaryJobs number of failed jobs listed in a summary
+const maxSummaryJobs = 10
+
+// failure a failed job
+type failure struct {
+	sequence int64
+	exitCode int
+	err      error
+	command  string
+}
+
+// Tally a count of finished and failed jobs
+type Tally struct {
+	mu        sync.Mutex
+	done      int64
+	failures  []failure
+	unmatched int64 // input lines that didn't match with --match-strict
+}
+
+// NewTally make a new tally
+func NewTally() *Tally {
+	return &Tally{}
+}
+
+// Record record the outcome of a finished job
+func (t *Tally) Record(c *Command, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done++
+	if err != nil {
+		t.failures = append(t.failures, failure{
+			sequence: c.GetSequence(),
+			exitCode: c.ExitCode,
+			err:      err,
+			command:  c.Command,
+		})
+	}
+}
+
+// Unmatched record an input line that didn't match when matching is strict
+func (t *Tally) Unmatched() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.unmatched++
+}
+
+// Done get the number of finished jobs
+func (t *Tally) Done() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.done
+}
+
+// Failed get the number of failed jobs
+func (t *Tally) Failed() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return int64(len(t.failures))
+}
+
+// Summary get a description of the failed jobs and unmatched input lines, empty if there are none
+func (t *Tally) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var sb strings.Builder
+	if t.unmatched > 0 {
+		sb.WriteString(fmt.Sprintf("%d input lines did not match\n", t.unmatched))
+	}
+	if len(t.failures) == 0 {
+		return sb.String()
+	}
+	sort.Slice(t.failures, func(i, j int) bool { return t.failures[i].sequence < t.failures[j].sequence })
+
+	sb.WriteString(fmt.Sprintf("%d of %d jobs failed\n", len(t.failures), t.done))
+	for i, f := range t.failures {
+		if i == maxSummaryJobs {
+			sb.WriteString(fmt.Sprintf("  and %d more\n", len(t.failures)-maxSummaryJobs))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("  job %d %v: %s\n", f.sequence, f.err, f.command))
+	}
+
+	return sb.String()
+}
+
+// ExitStatus get the exit status for the run using one of the exit status modes
+func (t *Tally) ExitStatus(mode string) (status int, err error) {
+	failed := t.Failed()
+	switch mode {
+	case ExitStatusCount, "":
+		status = int(failed)
+		if status > MaxExitStatus {
+			status = MaxExitStatus
+		}
+	case ExitStatusAny:
+		if failed > 0 {
+			status = 1
+		}
+	case ExitStatusAll:
+		if failed > 0 && failed == t.Done() {
+			status = 1
+		}
+	default:
+		err = fmt.Errorf("invalid exit status mode %s, expected %s, %s or %s",
+			mode, ExitStatusCount, ExitStatusAny, ExitStatusAll)
+	}
+	// A strict match fails the run even if every job succeeded
+	t.mu.Lock()
+	if err == nil && status == 0 && t.unmatched > 0 {
+		status = 1
+	}
+	t.mu.Unlock()
+
+	return
+}
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/alessio/shellescape"
+	"github.com/imarsman/concur/cmd/tasks"
+)
+
+// TemplateData what a command template can use
+type TemplateData struct {
+	Seq    int64      // sequence number of the job
+	Slot   int64      // slot the job runs in
+	Args   []string   // an item from each task list
+	Line   string     // the input line
+	Fields []string   // fields of the input line, split with the column separator if there is one
+	Items  []string   // inputs batched into the job with -n or -X, or just the input line
+	Batch  [][]string // items batched into the job from each task list
+}
+
+// templateFuncs functions a command template can use
+var templateFuncs = template.FuncMap{
+	"base": filepath.Base,
+	"dir":  filepath.Dir,
+	"noext": func(value string) string {
+		return strings.TrimSuffix(value, filepath.Ext(value))
+	},
+	"quote": shellescape.Quote,
+	"upper": strings.ToUpper,
+	"replace": func(old, new, value string) string {
+		return strings.ReplaceAll(value, old, new)
+	},
+	"printf": fmt.Sprintf,
+	"pad": func(width int, value interface{}) string {
+		str := fmt.Sprint(value)
+		if len(str) >= width {
+			return str
+		}
+		return strings.Repeat("0", width-len(str)) + str
+	},
+}
+
+// NewTemplate parse a command written as a Go text template
+func NewTemplate(text string) (*template.Template, error) {
+	return template.New("command").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate render the command template for a set of tasks
+func (c *Command) renderTemplate(taskSet []tasks.Task) (err error) {
+	data := TemplateData{
+		Seq:  c.GetSequence(),
+		Slot: c.GetSlotNumber(),
+	}
+	for _, t := range taskSet {
+		data.Args = append(data.Args, t.Task)
+		items := t.Items
+		if items == nil {
+			items = []string{t.Task}
+		}
+		data.Batch = append(data.Batch, items)
+	}
+	data.Items = data.Batch[0]
+	data.Line = taskSet[0].Task
+	for _, field := range c.fields(taskSet[:1]) {
+		data.Fields = append(data.Fields, field.Task)
+	}
+
+	var sb strings.Builder
+	err = c.Config.TextTemplate.Execute(&sb, data)
+	if err != nil {
+		return
+	}
+	c.Command = strings.TrimSpace(sb.String())
+
+	return
+}
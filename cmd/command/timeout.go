@@ -0,0 +1,136 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// minTimeoutSamples number of completed jobs needed before a percentage timeout applies
+const minTimeoutSamples = 3
+
+// Timeout a per job time limit. The limit is either a fixed duration or a percentage of the median runtime of the
+// jobs completed so far.
+type Timeout struct {
+	Duration time.Duration
+	Percent  float64
+	Grace    time.Duration
+	mu       sync.Mutex
+	runtimes []time.Duration
+}
+
+// NewTimeout make a new timeout from a value such as 30s or 200%
+func NewTimeout(value string, grace time.Duration) (timeout *Timeout, err error) {
+	timeout = &Timeout{Grace: grace}
+	value = strings.TrimSpace(value)
+
+	if strings.HasSuffix(value, "%") {
+		timeout.Percent, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			err = fmt.Errorf("invalid timeout percentage %s", value)
+			return
+		}
+		if timeout.Percent <= 0 {
+			err = fmt.Errorf("timeout percentage %s must be greater than zero", value)
+		}
+		return
+	}
+
+	timeout.Duration, err = time.ParseDuration(value)
+	if err != nil {
+		// Allow plain seconds
+		var seconds float64
+		seconds, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid timeout %s", value)
+			return
+		}
+		timeout.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout.Duration <= 0 {
+		err = fmt.Errorf("timeout %s must be greater than zero", value)
+	}
+
+	return
+}
+
+// Record add the runtime of a job that completed within its limit
+func (t *Timeout) Record(runtime time.Duration) {
+	if t.Percent == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := sort.Search(len(t.runtimes), func(i int) bool { return t.runtimes[i] >= runtime })
+	t.runtimes = append(t.runtimes, 0)
+	copy(t.runtimes[i+1:], t.runtimes[i:])
+	t.runtimes[i] = runtime
+}
+
+// Limit get the current time limit for a job. Zero means no limit applies yet.
+func (t *Timeout) Limit() time.Duration {
+	if t.Percent == 0 {
+		return t.Duration
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.runtimes) < minTimeoutSamples {
+		return 0
+	}
+	median := t.runtimes[len(t.runtimes)/2]
+
+	return time.Duration(float64(median) * t.Percent / 100)
+}
+
+// wait wait for a started command to finish. If a time limit applies and is exceeded the command's process is sent
+// SIGTERM and then SIGKILL if it is still running after the grace period.
+func (c *Command) wait(proc Process) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- proc.Wait()
+	}()
+
+	var limit time.Duration
+	if c.Config.Timeout != nil {
+		limit = c.Config.Timeout.Limit()
+	}
+	if limit == 0 {
+		err = <-done
+		if c.Config.Timeout != nil {
+			c.Config.Timeout.Record(time.Since(c.Start))
+		}
+		return
+	}
+
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+		c.Config.Timeout.Record(time.Since(c.Start))
+		return
+	case <-timer.C:
+	}
+
+	c.TimedOut = true
+	proc.Signal(syscall.SIGTERM)
+
+	grace := time.NewTimer(c.Config.Timeout.Grace)
+	defer grace.Stop()
+
+	select {
+	case err = <-done:
+	case <-grace.C:
+		proc.Signal(syscall.SIGKILL)
+		err = <-done
+	}
+	err = fmt.Errorf("timed out after %v: %w", limit, err)
+
+	return
+}
@@ -0,0 +1,280 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/imarsman/concur/cmd/parse"
+)
+
+// DefaultWorkerDelim marks the end of a worker's response to a task if no other delimiter is set
+const DefaultWorkerDelim = "\n"
+
+// WorkerExecutor run jobs with long lived worker processes, one for each slot
+// Workers are numbered from 1 and each is started with the command as given, with {%} replaced by the worker's
+// number, the first time it is used. A job takes whichever worker is free, writes its input to the worker's stdin as a
+// line and reads the worker's stdout up to the delimiter as the job's output. There is a worker for every slot, so a
+// running job never waits for another job's worker. A job reports the worker's number as its slot and the worker's
+// command as its command. A worker that exits or is signalled is started again for the next job.
+type WorkerExecutor struct {
+	Executor Executor  // starts the worker processes
+	Command  string    // command each worker is started with
+	Delim    string    // marks the end of a response
+	Stderr   io.Writer // where worker error output is written
+	workers  []*worker
+	free     chan *worker // workers not in use by a job
+}
+
+// NewWorkerExecutor make a new worker executor that starts a worker for each slot with another executor
+func NewWorkerExecutor(executor Executor, command string, slots int64, delim string) *WorkerExecutor {
+	if delim == "" {
+		delim = DefaultWorkerDelim
+	}
+	if slots < 1 {
+		slots = 1
+	}
+
+	e := WorkerExecutor{
+		Executor: executor,
+		Command:  command,
+		Delim:    delim,
+		Stderr:   os.Stderr,
+		free:     make(chan *worker, slots),
+	}
+	for i := int64(1); i <= slots; i++ {
+		w := &worker{id: i}
+		e.workers = append(e.workers, w)
+		e.free <- w
+	}
+
+	return &e
+}
+
+// worker a long lived process for a slot
+type worker struct {
+	id     int64      // number of the worker from 1
+	mu     sync.Mutex // held by the job using the worker
+	proc   Process
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// Start queue a job for the next free worker
+func (e *WorkerExecutor) Start(job Job) (Process, error) {
+	p := &workerProcess{done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		w := <-e.free
+		defer func() { e.free <- w }()
+		p.slot, p.command = w.id, e.workerCommand(w)
+		p.err = e.run(w, p, job)
+	}()
+
+	return p, nil
+}
+
+// run send a job's input to a worker and read its response
+func (e *WorkerExecutor) run(w *worker, p *workerProcess, job Job) (err error) {
+	// The input is sent as one line
+	var input []byte
+	if job.Stdin != nil {
+		input, err = io.ReadAll(job.Stdin)
+		if err != nil {
+			return
+		}
+	}
+	line := strings.ReplaceAll(strings.TrimRight(string(input), "\n"), "\n", " ")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The job may have been signalled before it got the worker
+	if p.signalled() != 0 {
+		return &ExitError{Code: -1, Signal: p.signalled()}
+	}
+	if w.proc == nil {
+		err = e.startWorker(w)
+		if err != nil {
+			return
+		}
+	}
+	if !p.attach(w.proc) {
+		e.stopWorker(w)
+		return &ExitError{Code: -1, Signal: p.signalled()}
+	}
+
+	_, err = io.WriteString(w.stdin, line+"\n")
+	if err == nil {
+		var response []byte
+		response, err = readResponse(w.stdout, e.Delim)
+		job.Stdout.Write(response)
+	}
+	p.attach(nil)
+	if err != nil {
+		waitErr := e.stopWorker(w)
+		if p.signalled() != 0 {
+			return &ExitError{Code: -1, Signal: p.signalled()}
+		}
+		if waitErr != nil {
+			err = waitErr
+		}
+		err = fmt.Errorf("worker %d stopped: %w", w.id, err)
+	}
+
+	return
+}
+
+// startWorker start the process for a worker
+func (e *WorkerExecutor) startWorker(w *worker) (err error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return
+	}
+	defer stdinR.Close()
+	defer stdoutW.Close()
+
+	stderr := e.Stderr
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	w.proc, err = e.Executor.Start(Job{
+		Command:  e.workerCommand(w),
+		Sequence: w.id,
+		Slot:     w.id,
+		Stdin:    stdinR,
+		Stdout:   stdoutW,
+		Stderr:   stderr,
+	})
+	if err != nil {
+		w.proc = nil
+		stdinW.Close()
+		stdoutR.Close()
+		return
+	}
+	w.stdin = stdinW
+	w.stdout = bufio.NewReader(stdoutR)
+
+	return
+}
+
+// workerCommand get the command a worker is started with
+func (e *WorkerExecutor) workerCommand(w *worker) string {
+	return strings.ReplaceAll(e.Command, parse.TokenSlot, fmt.Sprint(w.id))
+}
+
+// stopWorker kill a worker and wait for it to exit so it is started again for the next job
+func (e *WorkerExecutor) stopWorker(w *worker) (err error) {
+	w.stdin.Close()
+	w.proc.Signal(syscall.SIGKILL)
+	err = w.proc.Wait()
+	w.proc = nil
+
+	return
+}
+
+// readResponse read up to and not including the delimiter
+func readResponse(r *bufio.Reader, delim string) (response []byte, err error) {
+	last := delim[len(delim)-1]
+	var buf bytes.Buffer
+	for {
+		var chunk []byte
+		chunk, err = r.ReadBytes(last)
+		buf.Write(chunk)
+		if err != nil {
+			return buf.Bytes(), err
+		}
+		if bytes.HasSuffix(buf.Bytes(), []byte(delim)) {
+			return bytes.TrimSuffix(buf.Bytes(), []byte(delim)), nil
+		}
+	}
+}
+
+// Describe show the command each worker is started with, which is the same for every job
+func (e *WorkerExecutor) Describe(command string) string {
+	return e.Executor.Describe(e.Command)
+}
+
+// Close close the stdin of each worker and wait for it to exit
+func (e *WorkerExecutor) Close() error {
+	for _, w := range e.workers {
+		w.mu.Lock()
+		if w.proc != nil {
+			w.stdin.Close()
+			w.proc.Wait()
+			w.proc = nil
+		}
+		w.mu.Unlock()
+	}
+
+	return nil
+}
+
+// workerProcess a job sent to a worker
+type workerProcess struct {
+	done    chan struct{}
+	err     error
+	slot    int64  // number of the worker the job was sent to
+	command string // command the worker was started with
+	mu      sync.Mutex
+	proc    Process // worker process while the job is using it
+	signal  syscall.Signal
+}
+
+// attach record the worker process the job is using, or nil once the job is done with it
+// A job signalled before it gets the worker is not attached.
+func (p *workerProcess) attach(proc Process) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if proc != nil && p.signal != 0 {
+		return false
+	}
+	p.proc = proc
+
+	return true
+}
+
+// Placement get the number of the worker the job was sent to and the command the worker was started with
+func (p *workerProcess) Placement() (slot int64, command string) {
+	return p.slot, p.command
+}
+
+// signalled get the signal sent to the job, if any
+func (p *workerProcess) signalled() syscall.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.signal
+}
+
+// Wait wait for the worker's response
+func (p *workerProcess) Wait() error {
+	<-p.done
+
+	return p.err
+}
+
+// Signal signal the worker if the job is using it, otherwise keep the job from using it
+func (p *workerProcess) Signal(sig syscall.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signal == 0 {
+		p.signal = sig
+	}
+	if p.proc != nil {
+		return p.proc.Signal(sig)
+	}
+
+	return nil
+}
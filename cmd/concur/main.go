@@ -4,16 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"os/exec"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
-	"sync"
+	"text/template"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/imarsman/concur/cmd/awk"
+	"github.com/imarsman/concur/cmd/chunk"
 	"github.com/imarsman/concur/cmd/command"
+	"github.com/imarsman/concur/cmd/find"
+	"github.com/imarsman/concur/cmd/joblog"
 	"github.com/imarsman/concur/cmd/parse"
 	"github.com/imarsman/concur/cmd/tasks"
 	"github.com/posener/complete/v2"
@@ -38,8 +45,8 @@ func init() {
 }
 
 // readLines reads a whole file into memory
-// and returns a slice of its lines.
-func readLines(path string) ([]string, error) {
+// and returns a slice of its lines, split with a split function.
+func readLines(path string, split bufio.SplitFunc) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -48,27 +55,219 @@ func readLines(path string) ([]string, error) {
 
 	var lines []string
 	scanner := bufio.NewScanner(file)
+	scanner.Split(split)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
 	return lines, scanner.Err()
 }
 
+// headerNames get the names of fields from a comma separated list
+func headerNames(value string) (names []string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	for _, name := range strings.Split(value, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+
+	return
+}
+
+// listCount number of lists parsed so far, used to keep -a and --arg-file lists in the order they are given
+var listCount int64
+
+// listArg a list given with -a or --arg-file
+type listArg struct {
+	value string
+	order int64 // when the list was parsed
+}
+
+// UnmarshalText record a list as it is parsed
+// Flags are parsed in the order they are given, so the order of lists given with different flags is kept.
+func (l *listArg) UnmarshalText(text []byte) error {
+	l.value = string(text)
+	listCount++
+	l.order = listCount
+
+	return nil
+}
+
+// orderedLists get the lists given with -a and --arg-file in the order they were given
+// A list from --arg-file is the same as -a @file.
+func orderedLists(arguments, argFiles []listArg) (lists []string) {
+	for len(arguments) > 0 || len(argFiles) > 0 {
+		if len(argFiles) == 0 || (len(arguments) > 0 && arguments[0].order < argFiles[0].order) {
+			lists = append(lists, arguments[0].value)
+			arguments = arguments[1:]
+			continue
+		}
+		lists = append(lists, "@"+argFiles[0].value)
+		argFiles = argFiles[1:]
+	}
+
+	return
+}
+
+// listWords split a list given with -a into words
+// Without a delimiter words are split at whitespace with shell quoting and escapes, so "my file.txt" is one word.
+func listWords(list, delim string) (words []parse.Word, err error) {
+	if delim == "" {
+		return parse.ShellWords(list)
+	}
+	for _, word := range strings.Split(list, delim) {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			words = append(words, parse.Word{Text: word})
+		}
+	}
+
+	return
+}
+
+// expandWord get the items for a word in a list, expanding ranges and globs
+// A glob that matches nothing is kept as it is. ** in a glob matches any number of directories. Words with quoted
+// glob or brace characters are kept as they are.
+func expandWord(word parse.Word, includeDirs bool) (items []string, err error) {
+	if word.Literal {
+		return []string{word.Text}, nil
+	}
+	if parse.RERange.MatchString(word.Text) {
+		return parse.Range(word.Text)
+	}
+	matches, err := find.Glob(word.Text, includeDirs)
+	if err != nil || len(matches) == 0 {
+		return []string{word.Text}, nil
+	}
+
+	return matches, nil
+}
+
+// newFinder make a finder for --find with its filters
+func newFinder(callArgs Args) (finder *find.Finder, err error) {
+	info, err := os.Stat(callArgs.Find)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		err = fmt.Errorf("--find needs a directory, not %s", callArgs.Find)
+		return
+	}
+	finder = find.NewFinder(callArgs.Find)
+	finder.Name = callArgs.Name
+	finder.IName = callArgs.IName
+	finder.Exclude = callArgs.Exclude
+	finder.IncludeDirs = callArgs.IncludeDirs
+
+	var size int
+	if callArgs.MinSize != "" {
+		size, err = chunk.Size(callArgs.MinSize)
+		if err != nil {
+			return
+		}
+		finder.MinSize = int64(size)
+	}
+	if callArgs.MaxSize != "" {
+		size, err = chunk.Size(callArgs.MaxSize)
+		if err != nil {
+			return
+		}
+		finder.MaxSize = int64(size)
+	}
+	if callArgs.Newer != "" {
+		finder.Newer, err = find.ParseTime(callArgs.Newer, time.Now())
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// newChunkReader make a reader that splits stdin into blocks for --pipe
+func newChunkReader(callArgs Args) (chunks *chunk.Reader, err error) {
+	size, err := chunk.Size(callArgs.Block)
+	if err != nil {
+		return
+	}
+	chunks = chunk.NewReader(os.Stdin, size)
+	chunks.Lines = callArgs.Lines
+
+	recEnd, err := strconv.Unquote(`"` + callArgs.RecEnd + `"`)
+	if err != nil {
+		err = fmt.Errorf("invalid record end %s", callArgs.RecEnd)
+		return
+	}
+	recStart, err := strconv.Unquote(`"` + callArgs.RecStart + `"`)
+	if err != nil {
+		err = fmt.Errorf("invalid record start %s", callArgs.RecStart)
+		return
+	}
+	chunks.RecEnd = []byte(recEnd)
+	chunks.RecStart = []byte(recStart)
+
+	return
+}
+
 // Args command line arguments
 type Args struct {
-	Command     string   `arg:"positional"`
-	Arguments   []string `arg:"-a,--arguments,separate" help:"lists of arguments"`
-	Awk         string   `arg:"-A,--awk" help:"process using awk script or a script filename."`
-	DryRun      bool     `arg:"-d,--dry-run" help:"show command to run but don't run"`
-	Slots       int64    `arg:"-s,--slots" default:"8" help:"number of parallel tasks"`
-	Shuffle     bool     `arg:"-S,--shuffle" help:"shuffle tasks prior to running"`
-	Ordered     bool     `arg:"-o,--ordered" help:"run tasks in their incoming order"`
-	KeepOrder   bool     `arg:"-k,--keep-order" help:"don't keep output for calls separate"`
-	PrintEmpty  bool     `arg:"-P,--print-empty" help:"print empty lines"`
-	ExitOnError bool     `arg:"-E,--exit-on-error" help:"exit on first error"`
-	SplitAtNull bool     `arg:"-0,--null" help:"split at null character"`
-	IgnoreError bool     `arg:"-i,--ignore-error" help:"Ignore errors"`
-	StdIn       bool     `arg:"-I,--stdin" help:"send input to stdin"`
+	Command     string        `arg:"positional"`
+	Arguments   []listArg     `arg:"-a,--arguments,separate" help:"lists of arguments, or @file to read a list from a file"`
+	ArgFiles    []listArg     `arg:"--arg-file,separate" help:"files to read lists of arguments from, one per line"`
+	IncludeDirs bool          `arg:"--include-dirs" help:"include directories matched by globs and found with --find"`
+	Find        string        `arg:"--find" help:"use the files found under a directory as the input, as they are found"`
+	Name        []string      `arg:"--name,separate" help:"only find files whose name matches a glob such as *.go"`
+	IName       []string      `arg:"--iname,separate" help:"only find files whose name matches a glob ignoring case"`
+	MinSize     string        `arg:"--min-size" help:"only find files of at least a size such as 10k"`
+	MaxSize     string        `arg:"--max-size" help:"only find files of at most a size such as 10M"`
+	Newer       string        `arg:"--newer" help:"only find files modified after a file, a date such as 2024-01-31 or a duration ago such as 24h"`
+	Exclude     []string      `arg:"--exclude,separate" help:"leave out files and directories whose name or path matches a glob such as .git"`
+	ListDelim   string        `arg:"--list-delim" help:"split -a lists at this, such as , or \\n, instead of at whitespace with shell quoting"`
+	ColSep      string        `arg:"-C,--colsep" help:"split input lines into fields at this regular expression so {1}, {2} and so on are fields"`
+	Header      string        `arg:"--header" help:"comma separated names such as name,color for fields, for tokens such as {name}"`
+	HeaderLine  bool          `arg:"--header-line" help:"use the first input line for the names of fields, for tokens such as {name}"`
+	Match       string        `arg:"--match" help:"regular expression whose groups such as (?P<name>...) are tokens such as {name} and {1}, skipping lines that don't match"`
+	MatchStrict bool          `arg:"--match-strict" help:"report input lines that don't match --match and exit with an error"`
+	Template    bool          `arg:"--template" help:"the command is a Go text template using .Seq, .Slot, .Args, .Line, .Fields and .Items"`
+	Combine     string        `arg:"--combine" default:"zip" help:"how to combine argument lists (zip, product, zip-shortest, pad)"`
+	Delimiter   string        `arg:"--delimiter" help:"split stdin and argument files at this instead of a newline, with escapes such as \\t"`
+	Awk         string        `arg:"-A,--awk" help:"process using awk script or a script filename."`
+	DryRun      bool          `arg:"-d,--dry-run" help:"show command to run but don't run"`
+	Slots       int64         `arg:"-s,--slots" default:"8" help:"number of parallel tasks"`
+	Shuffle     bool          `arg:"-S,--shuffle" help:"shuffle tasks prior to running"`
+	Ordered     bool          `arg:"-o,--ordered" help:"run tasks in their incoming order"`
+	KeepOrder   bool          `arg:"-k,--keep-order" help:"print output in the order of the input while running in parallel"`
+	PrintEmpty  bool          `arg:"-P,--print-empty" help:"print empty lines"`
+	ExitOnError bool          `arg:"-E,--exit-on-error" help:"exit on first error, the same as --halt now,fail=1"`
+	SplitAtNull bool          `arg:"-0,--null" help:"split at null character"`
+	IgnoreError bool          `arg:"-i,--ignore-error" help:"exit with 0 even if jobs fail, still listing them"`
+	StdIn       bool          `arg:"-I,--stdin" help:"send input to stdin"`
+	Timeout     string        `arg:"-t,--timeout" help:"kill jobs running longer than a duration such as 30s or a percentage of the median job runtime such as 200%"`
+	Grace       time.Duration `arg:"--timeout-grace" default:"5s" help:"time to wait after SIGTERM before sending SIGKILL to a timed out job"`
+	Retries     int           `arg:"--retries" help:"number of times to retry a failed job"`
+	RetryDelay  time.Duration `arg:"--retry-delay" help:"time to wait before retrying a failed job"`
+	Backoff     bool          `arg:"--retry-backoff" help:"double the retry delay after each retry"`
+	RetryOn     string        `arg:"--retry-on" help:"only retry jobs exiting with one of these comma separated codes"`
+	JobLog      string        `arg:"--joblog" help:"write a tab separated log of finished jobs to a file"`
+	Resume      bool          `arg:"--resume" help:"skip jobs already in the job log"`
+	ResumeFail  bool          `arg:"--resume-failed" help:"skip jobs in the job log that succeeded and rerun those that failed"`
+	Tag         bool          `arg:"--tag" help:"prefix output lines with the job's input"`
+	TagString   string        `arg:"--tag-string" help:"prefix output lines with a template such as {#}:{/}"`
+	LineBuffer  bool          `arg:"--line-buffer" help:"print output lines as they are produced"`
+	JSON        bool          `arg:"--json" help:"print a JSON object with the result of each job"`
+	Halt        string        `arg:"--halt" help:"stop early with a policy such as now,fail=1 or soon,fail=10% or now,success=1"`
+	ExitStatus  string        `arg:"--exit-status" default:"count" help:"exit with the count of failed jobs up to 101, 1 if any failed or 1 if all failed (count, any, all)"`
+	Shell       string        `arg:"--shell" help:"shell to run commands with such as sh, zsh or dash (default bash)"`
+	NoShell     bool          `arg:"--no-shell" help:"run commands directly without a shell"`
+	Workers     bool          `arg:"--workers" help:"start the command once per slot and send each input to it as a line"`
+	WorkerDelim string        `arg:"--worker-delim" help:"end of a worker's response to an input, with escapes such as \\0 (default newline)"`
+	Pipe        bool          `arg:"--pipe" help:"split stdin into blocks and send each block to the stdin of a job"`
+	Block       string        `arg:"--block" default:"1M" help:"size of each block of piped input, extended to the end of a record"`
+	RecEnd      string        `arg:"--recend" default:"\\n" help:"end of a record in piped input, with escapes such as \\0"`
+	RecStart    string        `arg:"--recstart" help:"start of a record in piped input"`
+	Lines       int           `arg:"--lines" help:"send this many records to each job instead of a block"`
+	MaxArgs     int           `arg:"-n,--max-args" help:"run each job with up to this many inputs, with {} expanding to all of them"`
+	Xargs       bool          `arg:"-X,--xargs" help:"run each job with as many inputs as fit in a command line"`
 }
 
 // Version get version information
@@ -98,6 +297,24 @@ func main() {
 	cmd := &complete.Command{
 		Flags: map[string]complete.Predictor{
 			"arguments":     predict.Nothing,
+			"arg-file":      predict.Files("*"),
+			"list-delim":    predict.Nothing,
+			"include-dirs":  predict.Nothing,
+			"find":          predict.Dirs("*"),
+			"name":          predict.Nothing,
+			"iname":         predict.Nothing,
+			"min-size":      predict.Nothing,
+			"max-size":      predict.Nothing,
+			"newer":         predict.Files("*"),
+			"exclude":       predict.Nothing,
+			"delimiter":     predict.Nothing,
+			"colsep":        predict.Nothing,
+			"header":        predict.Nothing,
+			"header-line":   predict.Nothing,
+			"match":         predict.Nothing,
+			"match-strict":  predict.Nothing,
+			"template":      predict.Nothing,
+			"combine":       predict.Set{tasks.CombineZip, tasks.CombineProduct, tasks.CombineZipShortest, tasks.CombinePad},
 			"awk":           predict.Nothing,
 			"dry-run":       predict.Nothing,
 			"slots":         predict.Nothing,
@@ -109,6 +326,32 @@ func main() {
 			"null":          predict.Nothing,
 			"ignore-error":  predict.Nothing,
 			"stdin":         predict.Nothing,
+			"timeout":       predict.Nothing,
+			"timeout-grace": predict.Nothing,
+			"retries":       predict.Nothing,
+			"retry-delay":   predict.Nothing,
+			"retry-backoff": predict.Nothing,
+			"retry-on":      predict.Nothing,
+			"joblog":        predict.Files("*"),
+			"resume":        predict.Nothing,
+			"resume-failed": predict.Nothing,
+			"tag":           predict.Nothing,
+			"tag-string":    predict.Nothing,
+			"line-buffer":   predict.Nothing,
+			"json":          predict.Nothing,
+			"halt":          predict.Set{"never", "now,fail=1", "soon,fail=1", "now,success=1", "soon,success=1"},
+			"exit-status":   predict.Set{command.ExitStatusCount, command.ExitStatusAny, command.ExitStatusAll},
+			"shell":         predict.Files("*"),
+			"no-shell":      predict.Nothing,
+			"workers":       predict.Nothing,
+			"worker-delim":  predict.Nothing,
+			"pipe":          predict.Nothing,
+			"block":         predict.Nothing,
+			"recend":        predict.Nothing,
+			"recstart":      predict.Nothing,
+			"lines":         predict.Nothing,
+			"max-args":      predict.Nothing,
+			"xargs":         predict.Nothing,
 		},
 	}
 
@@ -153,181 +396,406 @@ func main() {
 		callArgs.Slots = int64(runtime.NumCPU())
 	}
 
-	// Make config to hold various parameters
-	config := command.Config{
-		Slots:       callArgs.Slots,
-		DryRun:      callArgs.DryRun,
-		KeepOrder:   callArgs.KeepOrder,
-		Concurrency: callArgs.Slots,
-		Awk:         awkCommand,
-		PrintEmpty:  callArgs.PrintEmpty,
-		ExitOnError: callArgs.ExitOnError,
-		StdIn:       callArgs.StdIn,
+	var timeout *command.Timeout
+	if callArgs.Timeout != "" {
+		var err error
+		timeout, err = command.NewTimeout(callArgs.Timeout, callArgs.Grace)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
-	taskListSet := tasks.NewTaskListSet()
+	var retry *command.Retry
+	if callArgs.Retries > 0 {
+		retry = &command.Retry{
+			Retries: callArgs.Retries,
+			Delay:   callArgs.RetryDelay,
+			Backoff: callArgs.Backoff,
+		}
+		var err error
+		retry.ExitCodes, err = parse.Numbers(callArgs.RetryOn)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	// Define command to run
-	var c = command.NewCommand(
-		callArgs.Command,
-		&taskListSet,
-		config,
-	)
-
-	c.SetConcurrency(callArgs.Slots)
-	var wg = new(sync.WaitGroup)
-
-	var foundArgumentList = false
-	if len(callArgs.Arguments) > 0 {
-		foundArgumentList = true
-		for _, v := range callArgs.Arguments {
-			taskList := tasks.NewTaskList()
-			parts := strings.Split(v, " ")
-
-			// TODO: handle reading file lines if that makes sense
-			for _, part := range parts {
-				part = strings.TrimSpace(part)
-				if parse.RERange.MatchString(part) {
-					items, err := parse.Range(part)
-					if err != nil {
-						fmt.Println(err)
-						return
-					}
-					taskList.Add(items...)
-				} else {
-					baseDir := filepath.Dir(part)
-					matches, err := filepath.Glob(part)
-					if err != nil {
-						continue
-					}
-					if len(matches) == 0 {
-						taskList.Add(strings.TrimSpace(part))
-					} else {
-						var files []string
-						for _, f := range matches {
-							f, _ := os.Stat(f)
-							if !f.IsDir() {
-								files = append(files, filepath.Join(baseDir, f.Name()))
-							}
-						}
-						taskList.Add(files...)
-					}
-				}
-				if callArgs.Shuffle {
-					taskList.Shuffle()
-				}
-			}
-			taskListSet.AddTaskList(taskList)
+	resume := callArgs.Resume || callArgs.ResumeFail
+	if resume && callArgs.JobLog == "" {
+		fmt.Println("--resume and --resume-failed require --joblog")
+		os.Exit(1)
+	}
+
+	// Sequence numbers of jobs finished in an earlier run and whether they failed
+	var finished = make(map[int64]bool)
+	if resume {
+		entries, err := joblog.Read(callArgs.JobLog)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		finished = joblog.Finished(entries)
 	}
 
-	stdin := false
+	// skip check whether a job with a sequence number was finished by an earlier run
+	var skip = func(sequence int64) bool {
+		failed, found := finished[sequence]
+		if !found {
+			return false
+		}
+		if callArgs.ResumeFail {
+			return !failed
+		}
 
-	// splitAtNull split at null terminator
-	var splitAtNull = func(input []byte, atEOF bool) (advance int, token []byte, err error) {
-		searchBytes := []byte("\000")
-		searchLen := len(searchBytes)
-		dataLen := len(input)
+		return true
+	}
 
-		// Return nothing if at end of file and no data passed
-		if atEOF && dataLen == 0 {
-			return 0, nil, nil
+	var jobLog *joblog.Log
+	if callArgs.JobLog != "" {
+		var err error
+		jobLog, err = joblog.Open(callArgs.JobLog, resume)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
 
-		// Find next separator and return token
-		if i := bytes.Index(input, searchBytes); i >= 0 {
-			return i + searchLen, input[0:i], nil
+	if callArgs.LineBuffer && callArgs.KeepOrder {
+		fmt.Println("--line-buffer can't be used with --keep-order")
+		os.Exit(1)
+	}
+	if callArgs.LineBuffer && callArgs.JSON {
+		fmt.Println("--line-buffer can't be used with --json")
+		os.Exit(1)
+	}
+	// JSON output is one object per job, so nothing else can be written to stdout
+	if callArgs.PrintEmpty && callArgs.JSON {
+		fmt.Println("--print-empty can't be used with --json")
+		os.Exit(1)
+	}
+	if callArgs.DryRun && callArgs.JSON {
+		fmt.Println("--dry-run can't be used with --json")
+		os.Exit(1)
+	}
+
+	if callArgs.ExitOnError && callArgs.Halt == "" {
+		callArgs.Halt = "now,fail=1"
+	}
+	halt, err := command.NewHalt(callArgs.Halt)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if callArgs.NoShell && callArgs.Shell != "" {
+		fmt.Println("--no-shell can't be used with --shell")
+		os.Exit(1)
+	}
+	var executor command.Executor
+	if callArgs.NoShell {
+		executor = command.NewDirectExecutor()
+	} else {
+		shell := callArgs.Shell
+		if shell == "" {
+			shell = command.DefaultShell
 		}
+		// Check for the shell before running anything as some hosts don't have bash
+		_, err = exec.LookPath(shell)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		executor = command.NewShellExecutor(shell)
+	}
+	// split split stdin and argument files into items
+	var split bufio.SplitFunc = bufio.ScanLines
+	if callArgs.SplitAtNull {
+		split = parse.SplitAt("\000")
+	} else if callArgs.Delimiter != "" {
+		delim, err := strconv.Unquote(`"` + callArgs.Delimiter + `"`)
+		if err != nil || delim == "" {
+			fmt.Printf("invalid delimiter %s\n", callArgs.Delimiter)
+			os.Exit(1)
+		}
+		split = parse.SplitAt(delim)
+	}
 
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return dataLen, input, nil
+	var chunks *chunk.Reader
+	if callArgs.Pipe {
+		if callArgs.Workers || len(callArgs.Arguments)+len(callArgs.ArgFiles) > 0 || callArgs.Tag || strings.TrimSpace(callArgs.Command) == "" {
+			fmt.Println("--pipe needs a command and can't be used with --workers, --arguments or --tag")
+			os.Exit(1)
+		}
+		chunks, err = newChunkReader(callArgs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// Each block is sent to stdin rather than placed in the command
+		callArgs.StdIn = true
+	}
+	var finder *find.Finder
+	if callArgs.Find != "" {
+		if callArgs.Pipe {
+			fmt.Println("--find can't be used with --pipe")
+			os.Exit(1)
+		}
+		finder, err = newFinder(callArgs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	} else if len(callArgs.Name)+len(callArgs.IName)+len(callArgs.Exclude) > 0 ||
+		callArgs.MinSize != "" || callArgs.MaxSize != "" || callArgs.Newer != "" {
+		fmt.Println("--name, --iname, --min-size, --max-size, --newer and --exclude need --find")
+		os.Exit(1)
+	}
+	if (callArgs.MaxArgs > 1 || callArgs.Xargs) && (callArgs.Pipe || callArgs.Workers) {
+		fmt.Println("--max-args and --xargs can't be used with --pipe or --workers")
+		os.Exit(1)
+	}
+	// Workers are started with the command as it is given rather than one made for each job
+	if callArgs.Template && callArgs.Workers {
+		fmt.Println("--template can't be used with --workers")
+		os.Exit(1)
+	}
+	var match *regexp.Regexp
+	if callArgs.Match != "" {
+		if callArgs.ColSep != "" || callArgs.Header != "" || callArgs.HeaderLine {
+			fmt.Println("--match can't be used with --colsep, --header or --header-line")
+			os.Exit(1)
+		}
+		match, err = regexp.Compile(callArgs.Match)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-		// Request more data.
-		return 0, nil, nil
+	var textTemplate *template.Template
+	if callArgs.Template {
+		textTemplate, err = command.NewTemplate(callArgs.Command)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
-	// Use stdin if it is available
-	// It will be the first task list if it is available. If there are other task lists they can be used as additional
-	// task items.
-	stat, _ := os.Stdin.Stat()
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
-		stdin = true
-		var scanner = bufio.NewScanner(os.Stdin)
+	if callArgs.Header != "" && callArgs.HeaderLine {
+		fmt.Println("--header can't be used with --header-line")
+		os.Exit(1)
+	}
+	header := headerNames(callArgs.Header)
+	// Fields with names are split at whitespace unless there is another separator
+	if (header != nil || callArgs.HeaderLine) && callArgs.ColSep == "" {
+		callArgs.ColSep = `\s+`
+	}
 
-		// Tell scanner to scan by lines.
-		if callArgs.SplitAtNull {
-			scanner.Split(splitAtNull)
-		} else {
-			scanner.Split(bufio.ScanLines)
-		}
-
-		var item string
-		for scanner.Scan() {
-			item = scanner.Text()
-			item = strings.TrimSpace(item)
-			// If we have just stdin and no -a lists handle them as they come.
-			if len(item) == 0 {
-				// Print out empty lines if that has been flagged
-				if callArgs.PrintEmpty {
-					c.Print(os.Stdout, "")
-				}
-				continue
-			}
-			var task = tasks.NewTask(item)
-			var taskSet []tasks.Task
-			taskSet = append(taskSet, *task)
+	var colSep *regexp.Regexp
+	if callArgs.ColSep != "" {
+		if callArgs.MaxArgs > 1 || callArgs.Xargs || callArgs.Pipe {
+			fmt.Println("--colsep can't be used with --max-args, --xargs or --pipe")
+			os.Exit(1)
+		}
+		colSep, err = regexp.Compile(callArgs.ColSep)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-			if foundArgumentList {
-				newTasks, err := taskListSet.NextAll()
-				if err != nil {
-				}
-				taskSet = append(taskSet, newTasks...)
-			}
-			c2 := c.Copy()
-			wg.Add(1)
-			err := command.RunCommand(c2, taskSet, wg)
+	var maxLength int
+	if callArgs.Xargs {
+		maxLength = command.MaxLength(executor)
+	}
+
+	if callArgs.Workers {
+		delim := command.DefaultWorkerDelim
+		if callArgs.WorkerDelim != "" {
+			delim, err = strconv.Unquote(`"` + callArgs.WorkerDelim + `"`)
 			if err != nil {
-				fmt.Println("got error", err)
+				fmt.Printf("invalid worker delimiter %s\n", callArgs.WorkerDelim)
 				os.Exit(1)
 			}
-			c.SequenceIncr()
 		}
+		executor = command.NewWorkerExecutor(executor, callArgs.Command, callArgs.Slots, delim)
+		// Workers get their input on stdin rather than in the command
+		callArgs.StdIn = true
 	}
 
-	// If we are not getting stdin run through and process all non-stdin list items
-	if !stdin {
-		// Run through as many iterations as the longest list
-		for i := 0; i < taskListSet.Max(); i++ {
-			wg.Add(1)
-			tasks, err := taskListSet.NextAll()
+	tally := command.NewTally()
+	// Check the mode before running anything
+	_, err = tally.ExitStatus(callArgs.ExitStatus)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-			empty := true
-			for _, t := range tasks {
-				if len(strings.TrimSpace(t.Task)) > 0 {
-					empty = false
-					continue
-				}
-			}
+	// Make config to hold various parameters
+	config := command.Config{
+		Slots:        callArgs.Slots,
+		DryRun:       callArgs.DryRun,
+		KeepOrder:    callArgs.KeepOrder,
+		Concurrency:  callArgs.Slots,
+		Awk:          awkCommand,
+		PrintEmpty:   callArgs.PrintEmpty,
+		StdIn:        callArgs.StdIn,
+		Pipe:         callArgs.Pipe,
+		Timeout:      timeout,
+		Retry:        retry,
+		JobLog:       jobLog,
+		Tag:          callArgs.Tag,
+		TagString:    callArgs.TagString,
+		LineBuffer:   callArgs.LineBuffer,
+		JSON:         callArgs.JSON,
+		Halt:         halt,
+		Tally:        tally,
+		Skip:         skip,
+		Executor:     executor,
+		MaxArgs:      callArgs.MaxArgs,
+		MaxLength:    maxLength,
+		ColSep:       colSep,
+		Header:       header,
+		HeaderLine:   callArgs.HeaderLine,
+		Match:        match,
+		MatchStrict:  callArgs.MatchStrict,
+		TextTemplate: textTemplate,
+	}
 
-			c2 := c.Copy()
-			if empty {
-				if callArgs.PrintEmpty {
-					c2.Print(os.Stdout, "")
-				}
-				continue
-			}
+	taskListSet := tasks.NewTaskListSet()
+	err = taskListSet.SetCombine(callArgs.Combine)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// The runner runs the command in parallel and the first signal cancels the context
+	runner := command.NewRunner(callArgs.Command, config)
+	ctx, interrupted := handleSignals(runner)
 
-			err = command.RunCommand(c2, tasks, wg)
+	// addFile add a task list with the items in a file
+	var addFile = func(path string) {
+		lines, err := readLines(path, split)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		taskList := tasks.NewTaskList()
+		taskList.Add(lines...)
+		if callArgs.Shuffle {
+			taskList.Shuffle()
+		}
+		taskListSet.AddTaskList(taskList)
+	}
+
+	// listDelim split -a lists at a delimiter rather than at whitespace
+	var listDelim string
+	if callArgs.ListDelim != "" {
+		listDelim, err = strconv.Unquote(`"` + callArgs.ListDelim + `"`)
+		if err != nil || listDelim == "" {
+			fmt.Printf("invalid list delimiter %s\n", callArgs.ListDelim)
+			os.Exit(1)
+		}
+	}
+
+	// addList add a task list from a list given with -a
+	var addList = func(v string) {
+		if strings.HasPrefix(v, "@") {
+			addFile(strings.TrimPrefix(v, "@"))
+			return
+		}
+		words, err := listWords(v, listDelim)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		taskList := tasks.NewTaskList()
+		for _, word := range words {
+			items, err := expandWord(word, callArgs.IncludeDirs)
 			if err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+			taskList.Add(items...)
+		}
+		if callArgs.Shuffle {
+			taskList.Shuffle()
+		}
+		taskListSet.AddTaskList(taskList)
+	}
+
+	// Lists are numbered in the order -a and --arg-file are given
+	for _, list := range orderedLists(callArgs.Arguments, callArgs.ArgFiles) {
+		addList(list)
+	}
 
-			c.SequenceIncr()
+	// Use stdin if it is available
+	// It will be streamed as the first task of each set if it is available. If there are task lists they are used as
+	// additional task items.
+	stat, _ := os.Stdin.Stat()
+	if callArgs.Pipe {
+		if (stat.Mode() & os.ModeCharDevice) != 0 {
+			fmt.Println("--pipe needs input on stdin")
+			os.Exit(1)
 		}
+
+		// Read blocks in the background so that reading can be abandoned when interrupted
+		var blocks = make(chan string)
+		go func() {
+			defer close(blocks)
+			for {
+				block, err := chunks.Next()
+				if err != nil {
+					if err != io.EOF {
+						fmt.Fprintln(os.Stderr, err)
+					}
+					return
+				}
+				blocks <- string(block)
+			}
+		}()
+
+		taskListSet.SetStream(blocks)
+	} else if finder != nil {
+		// Files are used as they are found and the walk stops if the run is interrupted
+		taskListSet.SetStream(finder.Stream(ctx.Done()))
+	} else if (stat.Mode() & os.ModeCharDevice) == 0 {
+		var scanner = bufio.NewScanner(os.Stdin)
+
+		scanner.Split(split)
+
+		// Read lines in the background so that reading can be abandoned when interrupted
+		var lines = make(chan string)
+		go func() {
+			defer close(lines)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+
+		taskListSet.SetStream(lines)
+	}
+
+	// Results are also written as output so there is nothing more to do with them
+	for range runner.Run(ctx, &taskListSet) {
 	}
 
-	wg.Wait()
+	jobLog.Close()
+
+	if summary := tally.Summary(); summary != "" {
+		fmt.Fprint(os.Stderr, summary)
+	}
+	if halt.Halted() {
+		os.Exit(halt.Status())
+	}
+	// Exit the way a shell reports a command ended by a signal
+	if signalled := interrupted(); signalled != 0 {
+		os.Exit(128 + int(signalled))
+	}
+	status, _ := tally.ExitStatus(callArgs.ExitStatus)
+	if callArgs.IgnoreError {
+		status = 0
+	}
+	os.Exit(status)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alexflint/go-arg"
+	"github.com/imarsman/concur/cmd/parse"
+	"github.com/matryer/is"
+)
+
+// parseArgs parse command line arguments the way main does
+func parseArgs(t *testing.T, args ...string) (callArgs Args) {
+	p, err := arg.NewParser(arg.Config{}, &callArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestHeader(t *testing.T) {
+	is := is.New(t)
+
+	// Names can follow --header after a space or an equals sign
+	callArgs := parseArgs(t, "echo {name}", "--header", "name, color", "-k")
+	is.Equal(callArgs.Command, "echo {name}")
+	is.Equal(headerNames(callArgs.Header), []string{"name", "color"})
+	is.True(!callArgs.HeaderLine)
+
+	callArgs = parseArgs(t, "--header=name,color", "echo {name}")
+	is.Equal(headerNames(callArgs.Header), []string{"name", "color"})
+
+	callArgs = parseArgs(t, "echo {name}", "--header-line")
+	is.True(callArgs.HeaderLine)
+	is.Equal(len(headerNames(callArgs.Header)), 0)
+}
+
+func TestExpandWord(t *testing.T) {
+	is := is.New(t)
+
+	expand := func(list string) (items []string) {
+		words, err := listWords(list, "")
+		is.NoErr(err)
+		for _, word := range words {
+			expanded, err := expandWord(word, false)
+			is.NoErr(err)
+			items = append(items, expanded...)
+		}
+		return
+	}
+
+	// Quoted ranges and globs are kept as they are
+	is.Equal(expand(`{1..2} "{1..2}" 'x*y.none' "a b"`), []string{"1", "2", "{1..2}", "x*y.none", "a b"})
+
+	_, err := expandWord(parse.Word{Text: "{1..3..0}"}, false)
+	is.True(err != nil)
+}
+
+func TestListOrder(t *testing.T) {
+	is := is.New(t)
+
+	// Lists keep the order they were given in and --arg-file is the same as -a @file
+	callArgs := parseArgs(t, "echo {1} {2}", "--arg-file", "hosts", "-a", "1 2", "--arg-file=ports", "-a=x")
+	is.Equal(orderedLists(callArgs.Arguments, callArgs.ArgFiles), []string{"@hosts", "1 2", "@ports", "x"})
+
+	// A flag given as the value of another flag isn't a list
+	callArgs = parseArgs(t, "--tag-string=-a", "--arguments", "a", "--", "--arg-file")
+	is.Equal(orderedLists(callArgs.Arguments, callArgs.ArgFiles), []string{"a"})
+	is.Equal(callArgs.Command, "--arg-file")
+}
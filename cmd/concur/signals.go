@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/imarsman/concur/cmd/command"
+)
+
+// handleSignals handle SIGINT, SIGTERM and SIGHUP
+// The first signal cancels the returned context so no more input is read and no new jobs are started while running jobs
+// are left to finish. A second signal sends SIGTERM to every running job's process group and a third sends SIGKILL.
+// The returned function gets the first signal received, zero if there was none.
+func handleSignals(runner *command.Runner) (ctx context.Context, interrupted func() syscall.Signal) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var first int32
+	signals := make(chan os.Signal, 3)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		count := 0
+		for sig := range signals {
+			count++
+			switch count {
+			case 1:
+				atomic.StoreInt32(&first, int32(sig.(syscall.Signal)))
+				fmt.Fprintf(os.Stderr, "got %v, waiting for running jobs, send again to terminate them\n", sig)
+				cancel()
+			case 2:
+				fmt.Fprintf(os.Stderr, "got %v, terminating running jobs\n", sig)
+				runner.Signal(syscall.SIGTERM)
+			default:
+				fmt.Fprintf(os.Stderr, "got %v, killing running jobs\n", sig)
+				runner.Signal(syscall.SIGKILL)
+			}
+		}
+	}()
+
+	interrupted = func() syscall.Signal {
+		return syscall.Signal(atomic.LoadInt32(&first))
+	}
+
+	return
+}
@@ -0,0 +1,341 @@
+// Find files by walking directories, for recursive globs and lists of files found under a directory
+
+package find
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errStop stops a stream's walk early
+var errStop = errors.New("stop walking")
+
+// stopError an error from visiting an entry, which stops a walk
+type stopError struct {
+	err error
+}
+
+// Error get the error from visiting the entry
+func (e stopError) Error() string {
+	return e.err.Error()
+}
+
+// Finder find entries under a root directory that pass its filters
+// Only entries that aren't directories are found unless IncludeDirs is set. Entries are found in lexical order as the
+// walk goes so there is no need to wait for the whole tree to be walked.
+type Finder struct {
+	Root        string    // directory to walk
+	Name        []string  // globs one of which the base name must match
+	IName       []string  // globs one of which the base name must match ignoring case
+	MinSize     int64     // smallest size of a file in bytes if more than 0
+	MaxSize     int64     // largest size of a file in bytes if more than 0
+	Newer       time.Time // entries must be modified after this if it is set
+	Exclude     []string  // globs for base names or paths under the root to leave out, along with what is under them
+	IncludeDirs bool      // find directories as well
+	Stderr      io.Writer // where errors reading directories are written
+	pattern     []string  // parts of a path under the root that must match, with ** matching any number of parts
+}
+
+// NewFinder make a new finder for a root directory
+func NewFinder(root string) *Finder {
+	f := Finder{
+		Root:   root,
+		Stderr: os.Stderr,
+	}
+
+	return &f
+}
+
+// Walk call visit with the path of each entry found. The walk stops with the error visit returns if it isn't nil.
+// Entries that can't be read are reported and skipped.
+func (f *Finder) Walk(visit func(path string) error) (err error) {
+	err = filepath.WalkDir(f.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if f.Stderr != nil {
+				fmt.Fprintln(f.Stderr, err)
+			}
+			if d != nil && d.IsDir() && path != f.Root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// The root directory itself is not an entry under it
+		if path == f.Root && d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(f.Root, path)
+		if err != nil {
+			return nil
+		}
+		if f.excluded(rel, d.Name()) || (d.IsDir() && !f.descend(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && !f.IncludeDirs {
+			return nil
+		}
+		if !f.matches(rel, d) {
+			return nil
+		}
+		if err := visit(path); err != nil {
+			return stopError{err}
+		}
+
+		return nil
+	})
+	var stop stopError
+	if errors.As(err, &stop) {
+		err = stop.err
+	}
+
+	return
+}
+
+// Stream send the path of each entry found to a channel, which is closed once the walk is done
+// Closing done stops the walk.
+func (f *Finder) Stream(done <-chan struct{}) <-chan string {
+	paths := make(chan string)
+	go func() {
+		defer close(paths)
+		f.Walk(func(path string) error {
+			select {
+			case paths <- path:
+				return nil
+			case <-done:
+				return errStop
+			}
+		})
+	}()
+
+	return paths
+}
+
+// excluded check whether an entry is excluded by its base name or its path under the root
+func (f *Finder) excluded(rel, name string) bool {
+	for _, exclude := range f.Exclude {
+		if ok, _ := filepath.Match(exclude, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(exclude, filepath.ToSlash(rel)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// descend check whether entries under a directory could match the pattern
+func (f *Finder) descend(rel string) bool {
+	if f.pattern == nil {
+		return true
+	}
+
+	return matchPrefix(f.pattern, strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+// matches check whether an entry passes the pattern and filters
+func (f *Finder) matches(rel string, d fs.DirEntry) bool {
+	if f.pattern != nil && !matchParts(f.pattern, strings.Split(filepath.ToSlash(rel), "/")) {
+		return false
+	}
+	if len(f.Name) > 0 && !matchAny(f.Name, d.Name()) {
+		return false
+	}
+	if len(f.IName) > 0 && !matchAny(lower(f.IName), strings.ToLower(d.Name())) {
+		return false
+	}
+	if f.MinSize == 0 && f.MaxSize == 0 && f.Newer.IsZero() {
+		return true
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	// Sizes only apply to files
+	if f.MinSize > 0 && !d.IsDir() && info.Size() < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && !d.IsDir() && info.Size() > f.MaxSize {
+		return false
+	}
+	if !f.Newer.IsZero() && !info.ModTime().After(f.Newer) {
+		return false
+	}
+
+	return true
+}
+
+// Glob get the paths matching a pattern, where ** matches any number of directories
+// Directories are left out unless includeDirs is set. As in a shell, names starting with a dot are only matched by
+// parts of the pattern that start with a dot.
+func Glob(pattern string, includeDirs bool) (paths []string, err error) {
+	if !strings.Contains(pattern, "**") {
+		var matches []string
+		matches, err = filepath.Glob(pattern)
+		if err != nil {
+			return
+		}
+		for _, match := range matches {
+			if hasHidden(pattern, match) {
+				continue
+			}
+			info, err := os.Stat(match)
+			if err == nil && (includeDirs || !info.IsDir()) {
+				paths = append(paths, match)
+			}
+		}
+		return
+	}
+
+	// Walk from the directory before the first part with a wildcard
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	var i int
+	for i < len(parts)-1 && !hasMeta(parts[i]) {
+		i++
+	}
+	for _, part := range parts[i:] {
+		if _, err = filepath.Match(part, ""); err != nil {
+			return
+		}
+	}
+	root := filepath.FromSlash(strings.Join(parts[:i], "/"))
+	if root == "" {
+		root = "."
+		if strings.HasPrefix(pattern, "/") {
+			root = "/"
+		}
+	}
+	if info, statErr := os.Stat(root); statErr != nil || !info.IsDir() {
+		return
+	}
+
+	f := NewFinder(root)
+	f.IncludeDirs = includeDirs
+	f.Stderr = nil
+	f.pattern = parts[i:]
+	err = f.Walk(func(path string) error {
+		paths = append(paths, path)
+		return nil
+	})
+
+	return
+}
+
+// ParseTime get a time from a file's modification time, a date such as 2024-01-31, a time such as
+// 2024-01-31T10:00:00Z or a duration before now such as 24h
+func ParseTime(value string, now time.Time) (t time.Time, err error) {
+	if info, statErr := os.Stat(value); statErr == nil {
+		return info.ModTime(), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		t, err = time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("invalid time %s, use a file, a date, a time or a duration", value)
+		return
+	}
+
+	return now.Add(-duration), nil
+}
+
+// matchParts check whether the parts of a path match the parts of a pattern
+func matchParts(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		return matchParts(pattern[1:], parts) ||
+			(len(parts) > 0 && !hidden(pattern[0], parts[0]) && matchParts(pattern, parts[1:]))
+	}
+	if len(parts) == 0 {
+		return false
+	}
+
+	return matchPart(pattern[0], parts[0]) && matchParts(pattern[1:], parts[1:])
+}
+
+// matchPrefix check whether the parts of a directory's path could start a match of the parts of a pattern
+func matchPrefix(pattern, parts []string) bool {
+	if len(parts) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return !hidden(pattern[0], parts[0]) || matchPrefix(pattern[1:], parts)
+	}
+
+	return matchPart(pattern[0], parts[0]) && matchPrefix(pattern[1:], parts[1:])
+}
+
+// matchPart check whether part of a path matches part of a pattern. Hidden names only match a pattern starting with a
+// dot, as in a shell.
+func matchPart(pattern, part string) bool {
+	if hidden(pattern, part) {
+		return false
+	}
+	ok, _ := filepath.Match(pattern, part)
+
+	return ok
+}
+
+// hidden check whether part of a path is hidden from part of a pattern because it starts with a dot and the pattern
+// doesn't
+func hidden(pattern, part string) bool {
+	return strings.HasPrefix(part, ".") && !strings.HasPrefix(pattern, ".")
+}
+
+// matchAny check whether a name matches any of a set of globs
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lower get globs in lower case
+func lower(globs []string) (lowered []string) {
+	for _, glob := range globs {
+		lowered = append(lowered, strings.ToLower(glob))
+	}
+
+	return
+}
+
+// hasHidden check whether a path matched by a pattern without ** has a hidden name where the pattern has a wildcard
+func hasHidden(pattern, path string) bool {
+	patternParts := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	pathParts := strings.Split(filepath.ToSlash(path), "/")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+	for i := range patternParts {
+		if pathParts[i] != patternParts[i] && hidden(patternParts[i], pathParts[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasMeta check whether part of a path has glob wildcards
+func hasMeta(part string) bool {
+	return strings.ContainsAny(part, `*?[\`)
+}
@@ -0,0 +1,155 @@
+package find
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// tree make files with some content under a temporary directory
+func tree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// found get the paths a finder finds relative to its root
+func found(t *testing.T, f *Finder) (paths []string) {
+	err := f.Walk(func(path string) error {
+		rel, _ := filepath.Rel(f.Root, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestFinder(t *testing.T) {
+	is := is.New(t)
+
+	root := tree(t, map[string]string{
+		"a.go":       "package a",
+		"b/c.GO":     strings.Repeat("x", 2000),
+		"b/d.txt":    "",
+		".git/e.go":  "",
+		"f/g/h.json": "{}",
+	})
+
+	is.Equal(found(t, NewFinder(root)), []string{".git/e.go", "a.go", "b/c.GO", "b/d.txt", "f/g/h.json"})
+
+	f := NewFinder(root)
+	f.Name = []string{"*.go", "*.txt"}
+	f.Exclude = []string{".git"}
+	is.Equal(found(t, f), []string{"a.go", "b/d.txt"})
+
+	f = NewFinder(root)
+	f.IName = []string{"*.go"}
+	f.MinSize = 1000
+	is.Equal(found(t, f), []string{"b/c.GO"})
+
+	f = NewFinder(root)
+	f.MaxSize = 5
+	f.Exclude = []string{"b/*"}
+	f.IncludeDirs = true
+	is.Equal(found(t, f), []string{".git", ".git/e.go", "b", "f", "f/g", "f/g/h.json"})
+
+	f = NewFinder(root)
+	f.Newer = time.Now().Add(time.Hour)
+	is.Equal(len(found(t, f)), 0)
+
+	// Visiting stops with the visitor's error
+	stop := errors.New("stop")
+	var count int
+	err := NewFinder(root).Walk(func(path string) error {
+		count++
+		return stop
+	})
+	is.Equal(err, stop)
+	is.Equal(count, 1)
+
+	// A stream ends once done is closed
+	done := make(chan struct{})
+	paths := NewFinder(root).Stream(done)
+	<-paths
+	close(done)
+	for range paths {
+	}
+}
+
+func TestGlob(t *testing.T) {
+	is := is.New(t)
+
+	root := tree(t, map[string]string{
+		"a.go":       "",
+		"b/c.go":     "",
+		"b/d/e.go":   "",
+		"b/d/f.txt":  "",
+		"g/b/h.go":   "",
+		"g/b/d/i.go": "",
+		".hid/j.go":  "",
+		"b/.k.go":    "",
+	})
+
+	glob := func(pattern string, includeDirs bool) (paths []string) {
+		matches, err := Glob(filepath.Join(root, pattern), includeDirs)
+		is.NoErr(err)
+		for _, match := range matches {
+			rel, _ := filepath.Rel(root, match)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return
+	}
+
+	is.Equal(glob("**/*.go", false), []string{"a.go", "b/c.go", "b/d/e.go", "g/b/d/i.go", "g/b/h.go"})
+	is.Equal(glob("b/**/*.go", false), []string{"b/c.go", "b/d/e.go"})
+	is.Equal(glob("**/b/d/*", false), []string{"b/d/e.go", "b/d/f.txt", "g/b/d/i.go"})
+	is.Equal(glob("**/d", true), []string{"b/d", "g/b/d"})
+	is.Equal(glob("*", false), []string{"a.go"})
+	is.Equal(glob("*", true), []string{"a.go", "b", "g"})
+	is.Equal(len(glob("x/**", false)), 0)
+
+	// Hidden names are only matched by parts of the pattern starting with a dot
+	is.Equal(glob(".hid/*.go", false), []string{".hid/j.go"})
+	is.Equal(glob(".*/**/*.go", false), []string{".hid/j.go"})
+	is.Equal(glob("**/.*.go", false), []string{"b/.k.go"})
+	is.Equal(glob("b/*", false), []string{"b/c.go"})
+
+	_, err := Glob("**/[", false)
+	is.True(err != nil)
+}
+
+func TestParseTime(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	parsed, err := ParseTime("36h", now)
+	is.NoErr(err)
+	is.Equal(parsed, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC))
+
+	parsed, err = ParseTime("2024-01-31T10:00:00Z", now)
+	is.NoErr(err)
+	is.True(parsed.Equal(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)))
+
+	_, err = ParseTime("2024-01-31", now)
+	is.NoErr(err)
+
+	_, err = ParseTime("yesterday", now)
+	is.True(err != nil)
+}
@@ -0,0 +1,211 @@
+// Write and read a log of finished jobs in a tab separated format compatible with the parallel joblog
+
+package joblog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalHost host name used for jobs run on the local machine
+const LocalHost = ":"
+
+// Header column names for the log
+var Header = []string{
+	"Seq", "Slot", "Host", "Starttime", "JobRuntime", "Send", "Receive", "Exitval", "Signal", "Command",
+}
+
+// Entry a finished job
+type Entry struct {
+	Sequence int64
+	Slot     int64
+	Host     string
+	Start    time.Time
+	Runtime  time.Duration
+	Send     int64
+	Receive  int64
+	ExitCode int
+	Signal   int
+	Command  string
+}
+
+// Failed did the job fail
+func (e Entry) Failed() bool {
+	return e.ExitCode != 0 || e.Signal != 0
+}
+
+// escaper and unescaper keep each command on a single tab separated row
+var escaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+var unescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+// String get the log row for an entry
+func (e Entry) String() string {
+	host := e.Host
+	if host == "" {
+		host = LocalHost
+	}
+	start := float64(e.Start.UnixNano()) / float64(time.Second)
+
+	return strings.Join([]string{
+		strconv.FormatInt(e.Sequence, 10),
+		strconv.FormatInt(e.Slot, 10),
+		host,
+		strconv.FormatFloat(start, 'f', 3, 64),
+		strconv.FormatFloat(e.Runtime.Seconds(), 'f', 3, 64),
+		strconv.FormatInt(e.Send, 10),
+		strconv.FormatInt(e.Receive, 10),
+		strconv.Itoa(e.ExitCode),
+		strconv.Itoa(e.Signal),
+		escaper.Replace(e.Command),
+	}, "\t")
+}
+
+// Log a job log file safe for use by concurrent jobs
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open open a job log, writing the header if the file is new or empty. The existing content is kept if appendTo is
+// true, otherwise the file is truncated.
+func Open(path string, appendTo bool) (log *Log, err error) {
+	flag := os.O_CREATE | os.O_WRONLY
+	if appendTo {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	if info.Size() == 0 {
+		_, err = fmt.Fprintln(file, strings.Join(Header, "\t"))
+		if err != nil {
+			file.Close()
+			return
+		}
+	}
+	log = &Log{file: file}
+
+	return
+}
+
+// Write add a row for a finished job
+func (l *Log) Write(entry Entry) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = fmt.Fprintln(l.file, entry.String())
+
+	return
+}
+
+// Close close the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// Parse get an entry from a log row
+func Parse(row string) (entry Entry, err error) {
+	fields := strings.SplitN(row, "\t", len(Header))
+	if len(fields) != len(Header) {
+		err = fmt.Errorf("job log row has %d fields, expected %d", len(fields), len(Header))
+		return
+	}
+
+	entry.Sequence, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	entry.Slot, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return
+	}
+	entry.Host = fields[2]
+	start, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return
+	}
+	entry.Start = time.Unix(0, int64(start*float64(time.Second)))
+	runtime, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return
+	}
+	entry.Runtime = time.Duration(runtime * float64(time.Second))
+	entry.Send, err = strconv.ParseInt(fields[5], 10, 64)
+	if err != nil {
+		return
+	}
+	entry.Receive, err = strconv.ParseInt(fields[6], 10, 64)
+	if err != nil {
+		return
+	}
+	entry.ExitCode, err = strconv.Atoi(fields[7])
+	if err != nil {
+		return
+	}
+	entry.Signal, err = strconv.Atoi(fields[8])
+	if err != nil {
+		return
+	}
+	entry.Command = unescaper.Replace(fields[9])
+
+	return
+}
+
+// Finished get the sequence numbers of the jobs in a log. The value is true if the last run of the job failed.
+func Finished(entries []Entry) (finished map[int64]bool) {
+	finished = make(map[int64]bool)
+	for _, entry := range entries {
+		finished[entry.Sequence] = entry.Failed()
+	}
+
+	return
+}
+
+// Read read all entries from a job log file
+func Read(path string) (entries []Entry, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		row := scanner.Text()
+		if row == "" || strings.HasPrefix(row, Header[0]+"\t") {
+			continue
+		}
+		var entry Entry
+		entry, err = Parse(row)
+		if err != nil {
+			err = fmt.Errorf("%s line %d: %v", path, line, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	err = scanner.Err()
+
+	return
+}
@@ -0,0 +1,58 @@
+package joblog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestLog(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "joblog.tsv")
+	log, err := Open(path, false)
+	is.NoErr(err)
+
+	start := time.Unix(1650000000, 0)
+	err = log.Write(Entry{Sequence: 1, Slot: 1, Start: start, Runtime: 1500 * time.Millisecond, Command: "echo 'a\tb'"})
+	is.NoErr(err)
+	err = log.Write(Entry{Sequence: 2, Slot: 2, Start: start, ExitCode: 1, Command: "exit 1"})
+	is.NoErr(err)
+	is.NoErr(log.Close())
+
+	// Appending keeps existing rows and does not repeat the header
+	log, err = Open(path, true)
+	is.NoErr(err)
+	err = log.Write(Entry{Sequence: 3, Slot: 1, Start: start, Signal: 15, Command: "sleep 10"})
+	is.NoErr(err)
+	is.NoErr(log.Close())
+
+	entries, err := Read(path)
+	is.NoErr(err)
+	is.Equal(len(entries), 3)
+	is.Equal(entries[0].Command, "echo 'a\tb'")
+	is.Equal(entries[0].Host, LocalHost)
+	is.Equal(entries[0].Runtime, 1500*time.Millisecond)
+	is.True(!entries[0].Failed())
+	is.True(entries[1].Failed())
+	is.True(entries[2].Failed())
+	is.Equal(entries[2].Start.Unix(), start.Unix())
+}
+
+func TestFinished(t *testing.T) {
+	is := is.New(t)
+
+	finished := Finished([]Entry{
+		{Sequence: 1},
+		{Sequence: 2, ExitCode: 1},
+		{Sequence: 3, Signal: 9},
+		{Sequence: 3},
+	})
+	is.Equal(len(finished), 3)
+	is.True(!finished[1])
+	is.True(finished[2])
+	// The last run of a job counts
+	is.True(!finished[3])
+}
@@ -1,9 +1,15 @@
 package parse
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 const (
@@ -25,22 +31,89 @@ var REToken = regexp.MustCompile(`.*\{\d*[\/\.]*\}.*`)
 var RENumberedToken = regexp.MustCompile(`\{(?:\d*[\/\.]+){0,1}\}`)
 
 // RENumbered regular expression for just a number
+//
+// Deprecated: use REInputToken, which matches every input token in one pass.
 var RENumbered = regexp.MustCompile(`\{(?P<NUMBER>\d+)\}`)
 
 // RENumberedWithNoExtension number for token indicating no file extension
+//
+// Deprecated: use REInputToken, which matches every input token in one pass.
 var RENumberedWithNoExtension = regexp.MustCompile(`\{(?P<NUMBER>\d)+\.\}`)
 
 // RENumberedBasename number for token indicating basename
+//
+// Deprecated: use REInputToken, which matches every input token in one pass.
 var RENumberedBasename = regexp.MustCompile(`\{(?P<NUMBER>\d+)\/\}`)
 
 // RENumberedDirname number for token indicating dirname
+//
+// Deprecated: use REInputToken, which matches every input token in one pass.
 var RENumberedDirname = regexp.MustCompile(`\{(?P<NUMBER>\d+)\/\/\}`)
 
 // RENumberedBasenameNoExtension number for token indicating basename with no extension
+//
+// Deprecated: use REInputToken, which matches every input token in one pass.
 var RENumberedBasenameNoExtension = regexp.MustCompile(`\{(?P<NUMBER>\d+)\.\/\}`)
 
-// RERange regular expression for a range such as {0..9}
-var RERange = regexp.MustCompile(`\{(?P<START>\d+)\.\.(?P<END>\d+)\}`)
+// REInputToken a token for an input with an optional list or field number or field name and a modifier such as {},
+// {/}, {2}, {2/.} or {name.}
+var REInputToken = regexp.MustCompile(`\{(\d*|[A-Za-z_]\w*)(//|/\.|\./|/|\.)?\}`)
+
+// REAwkToken a token with an awk expression to evaluate against the input line such as {= awk: toupper($1) =}
+var REAwkToken = regexp.MustCompile(`(?s)\{=\s*awk:(.*?)=\}`)
+
+// RESubstitutionToken an awk expression token or an input token so both can be replaced in one pass. The first group
+// is the awk expression and the second and third are the input token's number or name and modifier.
+var RESubstitutionToken = regexp.MustCompile(REAwkToken.String() + `|` + REInputToken.String())
+
+// IsName check whether the part of a token before its modifier is a name rather than a number
+func IsName(value string) bool {
+	return value != "" && !unicode.IsDigit(rune(value[0]))
+}
+
+// Modifiers changes made to an input by the modifier at the end of a token
+var Modifiers = map[string]func(string) string{
+	"":   func(input string) string { return input },
+	".":  noExtension,
+	"/":  filepath.Base,
+	"//": filepath.Dir,
+	"/.": baseNameNoExtension,
+	"./": baseNameNoExtension,
+}
+
+// noExtension the input without its file extension
+func noExtension(input string) string {
+	dir := filepath.Dir(input)
+	base := filepath.Base(input)
+
+	return filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// baseNameNoExtension the input's basename without its file extension
+func baseNameNoExtension(input string) string {
+	base := filepath.Base(input)
+
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// reRangePart a single range of dates, numbers or letters, each with an optional step
+const reRangePart = `(?:\d{4}-\d{2}-\d{2}\.\.\d{4}-\d{2}-\d{2}(?:\.\.\d+[dwmy]?)?` +
+	`|-?\d+\.\.-?\d+(?:\.\.-?\d+)?` +
+	`|[A-Za-z]\.\.[A-Za-z](?:\.\.-?\d+)?)`
+
+// RERange regular expression for a token with one or more comma separated ranges such as {0..9}, {0..100..5},
+// {10..1}, {001..120}, {a..z}, {2024-01-01..2024-03-31..1d} or {1..3,7..9}
+// Both ends of a range must be numbers, letters or dates, so the likes of {foo..bar} are not ranges.
+var RERange = regexp.MustCompile(`\{` + reRangePart + `(?:,` + reRangePart + `)*\}`)
+
+// reDate a date in a date range
+var reDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// reDateStep a step in a date range such as 1d, 2w, 1m or 1y
+var reDateStep = regexp.MustCompile(`^(\d+)([dwmy]?)$`)
+
+// dateLayout layout of the dates in a date range
+const dateLayout = "2006-01-02"
 
 /**
  * Parses input with the given regular expression and returns the
@@ -59,6 +132,8 @@ func params(regEx *regexp.Regexp, input string) (paramsMap map[string]string) {
 }
 
 // NumberFromToken get a number from a token
+//
+// Deprecated: use REInputToken, whose first group is the number.
 func NumberFromToken(re *regexp.Regexp, input string) (found bool, number int, err error) {
 	params := params(re, input)
 
@@ -74,30 +149,341 @@ func NumberFromToken(re *regexp.Regexp, input string) (found bool, number int, e
 	return
 }
 
-// Range get a range from its token
+// Range get the items of the ranges in a token
+// A range is START..END or START..END..STEP and counts down if START is after END. Numbers with leading zeros are
+// padded to the width of the widest, letters go through the alphabet and dates step a day at a time unless a step
+// such as 2d, 1w, 1m or 1y is given. Text around the token is kept around each item, so file{1..2}.txt is file1.txt
+// and file2.txt, and further ranges after the token are expanded for each item.
 func Range(input string) (rng []string, err error) {
-	params := params(RERange, input)
-	if params["START"] != "" && params["END"] != "" {
-		var start, end int
-		start, err = strconv.Atoi(params["START"])
+	loc := RERange.FindStringIndex(input)
+	if loc == nil {
+		err = fmt.Errorf("input %s start and/or end not found", input)
+		return
+	}
+	prefix, token, suffix := input[:loc[0]], input[loc[0]:loc[1]], input[loc[1]:]
+
+	suffixes := []string{suffix}
+	if RERange.MatchString(suffix) {
+		suffixes, err = Range(suffix)
 		if err != nil {
 			return
 		}
-		end, err = strconv.Atoi(params["END"])
+	}
+	for _, part := range strings.Split(token[1:len(token)-1], ",") {
+		var items []string
+		items, err = rangeItems(part)
 		if err != nil {
+			err = fmt.Errorf("range %s: %v", input, err)
 			return
 		}
-		if start > end {
-			err = fmt.Errorf("range %s has start %d > end %d", input, start, end)
+		for _, item := range items {
+			for _, suffix := range suffixes {
+				rng = append(rng, prefix+item+suffix)
+			}
+		}
+	}
+
+	return
+}
+
+// rangeItems get the items of a single range such as 1..10..2
+func rangeItems(part string) (items []string, err error) {
+	bounds := strings.Split(part, "..")
+	start, end := bounds[0], bounds[1]
+	var step string
+	if len(bounds) == 3 {
+		step = bounds[2]
+	}
+
+	switch {
+	case reDate.MatchString(start) && reDate.MatchString(end):
+		return dateRange(start, end, step)
+	case len(start) == 1 && len(end) == 1 && isLetter(start[0]) && isLetter(end[0]):
+		var by int
+		by, err = rangeStep(step)
+		if err != nil {
 			return
 		}
-		for i := start; i <= end; i++ {
-			rng = append(rng, fmt.Sprint(i))
+		for _, i := range steps(int(start[0]), int(end[0]), by) {
+			items = append(items, string(rune(i)))
+		}
+		return
+	}
+
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		err = fmt.Errorf("invalid start %s", start)
+		return
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil {
+		err = fmt.Errorf("invalid end %s", end)
+		return
+	}
+	by, err := rangeStep(step)
+	if err != nil {
+		return
+	}
+	// Leading zeros pad every number to the width of the widest bound
+	var width int
+	if padded(start) || padded(end) {
+		width = len(start)
+		if len(end) > width {
+			width = len(end)
+		}
+	}
+	for _, i := range steps(from, to, by) {
+		if i < 0 {
+			items = append(items, fmt.Sprintf("-%0*d", width-1, -i))
+		} else {
+			items = append(items, fmt.Sprintf("%0*d", width, i))
+		}
+	}
+
+	return
+}
+
+// dateRange get the dates from a start to an end date inclusive
+func dateRange(start, end, step string) (items []string, err error) {
+	from, err := time.Parse(dateLayout, start)
+	if err != nil {
+		err = fmt.Errorf("invalid start %s", start)
+		return
+	}
+	to, err := time.Parse(dateLayout, end)
+	if err != nil {
+		err = fmt.Errorf("invalid end %s", end)
+		return
+	}
+	if step == "" {
+		step = "1d"
+	}
+	match := reDateStep.FindStringSubmatch(step)
+	if match == nil {
+		err = fmt.Errorf("invalid step %s", step)
+		return
+	}
+	by, _ := strconv.Atoi(match[1])
+	if by == 0 {
+		err = fmt.Errorf("invalid step %s", step)
+		return
+	}
+	if to.Before(from) {
+		by = -by
+	}
+
+	// Each date is worked out from the start so that stepping by months keeps to the same day where possible
+	for i := 0; ; i++ {
+		var date time.Time
+		switch match[2] {
+		case "w":
+			date = from.AddDate(0, 0, 7*by*i)
+		case "m":
+			date = addMonths(from, by*i)
+		case "y":
+			date = addMonths(from, 12*by*i)
+		default:
+			date = from.AddDate(0, 0, by*i)
+		}
+		if (by > 0 && date.After(to)) || (by < 0 && date.Before(to)) {
+			break
+		}
+		items = append(items, date.Format(dateLayout))
+	}
+
+	return
+}
+
+// addMonths add months to a date, keeping to the last day of the month for days the month doesn't have
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	day := date.Day()
+	if day > last {
+		day = last
+	}
+
+	return first.AddDate(0, 0, day-1)
+}
+
+// rangeStep get the size of a step in a range, which is 1 if there isn't one
+func rangeStep(step string) (by int, err error) {
+	if step == "" {
+		return 1, nil
+	}
+	by, err = strconv.Atoi(step)
+	if err != nil || by == 0 {
+		err = fmt.Errorf("invalid step %s", step)
+		return
+	}
+	if by < 0 {
+		by = -by
+	}
+
+	return
+}
+
+// steps count from a start to an end inclusive, counting down if the end is before the start
+func steps(from, to, by int) (values []int) {
+	if from <= to {
+		for i := from; i <= to; i += by {
+			values = append(values, i)
 		}
 	} else {
-		err = fmt.Errorf("input %s start and/or end not found", input)
+		for i := from; i >= to; i -= by {
+			values = append(values, i)
+		}
+	}
+
+	return
+}
+
+// padded check whether a number in a range has leading zeros
+func padded(number string) bool {
+	number = strings.TrimPrefix(number, "-")
+
+	return len(number) > 1 && number[0] == '0'
+}
+
+// isLetter check whether a character is an ASCII letter
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Numbers get a list of integers from a comma separated string such as 1,75
+func Numbers(input string) (numbers []int, err error) {
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var number int
+		number, err = strconv.Atoi(part)
+		if err != nil {
+			err = fmt.Errorf("invalid number %s in %s", part, input)
+			return
+		}
+		numbers = append(numbers, number)
+	}
+
+	return
+}
+
+// Word a word split from a string the way a shell would
+type Word struct {
+	Text    string // the word without its quotes and escapes
+	Literal bool   // a glob or brace character in the word was quoted or escaped so the word is not expanded
+}
+
+// Words split a string into words the way a POSIX shell would, without any expansion
+// Words are separated by unquoted whitespace. Single quotes keep everything up to the next single quote, double quotes
+// allow a backslash to escape \ " $ and `, and a backslash outside of quotes escapes the next character.
+func Words(input string) (words []string, err error) {
+	shellWords, err := ShellWords(input)
+	for _, word := range shellWords {
+		words = append(words, word.Text)
+	}
+
+	return
+}
+
+// ShellWords split a string into words the way Words does, noting which words have quoted or escaped glob or brace
+// characters so that they can be kept as they are rather than expanded
+func ShellWords(input string) (words []Word, err error) {
+	var word strings.Builder
+	var inWord bool
+	var literal bool
+	var quote rune
+	var escaped bool
+
+	// quoted add a quoted or escaped character to the word
+	var quoted = func(r rune) {
+		if strings.ContainsRune("*?[{", r) {
+			literal = true
+		}
+		word.WriteRune(r)
+	}
+
+	for _, r := range input {
+		switch {
+		case escaped:
+			// A backslash and newline outside of single quotes joins lines
+			if r != '\n' {
+				if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+					word.WriteRune('\\')
+				}
+				quoted(r)
+			}
+			escaped = false
+			inWord = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				quoted(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				quoted(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, Word{Text: word.String(), Literal: literal})
+				word.Reset()
+				inWord = false
+				literal = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped {
+		err = fmt.Errorf("trailing backslash in %s", input)
+		return
+	}
+	if quote != 0 {
+		err = fmt.Errorf("unterminated %c quote in %s", quote, input)
 		return
 	}
+	if inWord {
+		words = append(words, Word{Text: word.String(), Literal: literal})
+	}
 
 	return
 }
+
+// SplitAt make a split function for a scanner that splits input at a delimiter such as the null character
+func SplitAt(delim string) bufio.SplitFunc {
+	searchBytes := []byte(delim)
+	searchLen := len(searchBytes)
+
+	return func(input []byte, atEOF bool) (advance int, token []byte, err error) {
+		dataLen := len(input)
+
+		// Return nothing if at end of file and no data passed
+		if atEOF && dataLen == 0 {
+			return 0, nil, nil
+		}
+
+		// Find next separator and return token
+		if i := bytes.Index(input, searchBytes); i >= 0 {
+			return i + searchLen, input[0:i], nil
+		}
+
+		// If we're at EOF, we have a final, non-terminated line. Return it.
+		if atEOF {
+			return dataLen, input, nil
+		}
+
+		// Request more data.
+		return 0, nil, nil
+	}
+}
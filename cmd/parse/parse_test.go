@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"bufio"
 	"fmt"
 	"strings"
 	"testing"
@@ -31,3 +32,101 @@ func TestCommand(t *testing.T) {
 	}
 	is.True(1 == 1)
 }
+
+func TestRange(t *testing.T) {
+	is := is.New(t)
+
+	for input, expected := range map[string][]string{
+		"{1..3}":                             {"1", "2", "3"},
+		"{0..20..5}":                         {"0", "5", "10", "15", "20"},
+		"{10..0..4}":                         {"10", "6", "2"},
+		"{008..011}":                         {"008", "009", "010", "011"},
+		"{-1..01}":                           {"-1", "00", "01"},
+		"{x..z}":                             {"x", "y", "z"},
+		"{E..A..2}":                          {"E", "C", "A"},
+		"{2024-02-27..2024-03-01}":           {"2024-02-27", "2024-02-28", "2024-02-29", "2024-03-01"},
+		"{2024-01-31..2024-04-01..1m}":       {"2024-01-31", "2024-02-29", "2024-03-31"},
+		"{2024-01-15..2024-01-01..1w}":       {"2024-01-15", "2024-01-08", "2024-01-01"},
+		"{2020-02-29..2022-03-01..1y}":       {"2020-02-29", "2021-02-28", "2022-02-28"},
+		"{1..2,b..c,2024-01-01..2024-01-01}": {"1", "2", "b", "c", "2024-01-01"},
+		"file{1..3}.txt":                     {"file1.txt", "file2.txt", "file3.txt"},
+		"x{1..2}-{a..b}":                     {"x1-a", "x1-b", "x2-a", "x2-b"},
+	} {
+		rng, err := Range(input)
+		is.NoErr(err)
+		is.Equal(rng, expected)
+	}
+
+	for _, input := range []string{"{1..3..0}", "{2024-13-01..2024-01-02}"} {
+		_, err := Range(input)
+		is.True(err != nil)
+	}
+
+	// Only numbers, letters and dates make ranges
+	for _, input := range []string{"{foo..bar}", "{a..3}", "{2024-01-01..2024-01-02..1x}", "{1,2}", "{1..}"} {
+		is.True(!RERange.MatchString(input))
+	}
+}
+
+func TestNumberFromToken(t *testing.T) {
+	is := is.New(t)
+
+	found, number, err := NumberFromToken(RENumberedBasename, "cp {12/} x")
+	is.NoErr(err)
+	is.True(found)
+	is.Equal(number, 12)
+
+	found, _, err = NumberFromToken(RENumbered, "cp {/} x")
+	is.NoErr(err)
+	is.True(!found)
+}
+
+func TestNumbers(t *testing.T) {
+	is := is.New(t)
+
+	numbers, err := Numbers("1, 75")
+	is.NoErr(err)
+	is.Equal(numbers, []int{1, 75})
+
+	_, err = Numbers("1,x")
+	is.True(err != nil)
+}
+
+func TestWords(t *testing.T) {
+	is := is.New(t)
+
+	words, err := Words(`echo  'a b' "c \"d\" \x" e\ f ''`)
+	is.NoErr(err)
+	is.Equal(words, []string{"echo", "a b", `c "d" \x`, "e f", ""})
+
+	_, err = Words(`echo 'a`)
+	is.True(err != nil)
+	_, err = Words(`echo a\`)
+	is.True(err != nil)
+}
+
+func TestShellWords(t *testing.T) {
+	is := is.New(t)
+
+	words, err := ShellWords(`"*.txt" *.txt '{1..3}' "my dir"/*.go a\?`)
+	is.NoErr(err)
+	is.Equal(words, []Word{
+		{Text: "*.txt", Literal: true},
+		{Text: "*.txt"},
+		{Text: "{1..3}", Literal: true},
+		{Text: "my dir/*.go"},
+		{Text: "a?", Literal: true},
+	})
+}
+
+func TestSplitAt(t *testing.T) {
+	is := is.New(t)
+
+	scanner := bufio.NewScanner(strings.NewReader("a b\x00c\nd\x00\x00e"))
+	scanner.Split(SplitAt("\x00"))
+	var items []string
+	for scanner.Scan() {
+		items = append(items, scanner.Text())
+	}
+	is.Equal(items, []string{"a b", "c\nd", "", "e"})
+}
@@ -0,0 +1,176 @@
+// Release job output in sequence order while jobs run in parallel
+
+package reorder
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// DefaultLimit bytes of waiting output held in memory before output is spilled to temporary files
+const DefaultLimit = 64 << 20
+
+// entry output for a job waiting for earlier jobs
+type entry struct {
+	stdout []byte
+	stderr []byte
+	path   string // temporary file holding stdout followed by stderr if spilled
+	split  int    // length of stdout in the temporary file
+}
+
+// Buffer a reorder buffer that holds the output of each job until the output of all jobs with lower sequence
+// numbers has been written
+type Buffer struct {
+	mu      sync.Mutex
+	next    int64
+	pending map[int64]*entry
+	memory  int64
+	limit   int64
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// New make a new reorder buffer that expects sequence numbers starting at start
+func New(start, limit int64, stdout, stderr io.Writer) *Buffer {
+	b := Buffer{
+		next:    start,
+		pending: make(map[int64]*entry),
+		limit:   limit,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+
+	return &b
+}
+
+// Add add the output of a job. The output is written once all earlier jobs have been added or skipped.
+func (b *Buffer) Add(sequence int64, stdout, stderr []byte) (err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sequence < b.next {
+		return b.write(&entry{stdout: stdout, stderr: stderr})
+	}
+
+	e := &entry{stdout: stdout, stderr: stderr}
+	size := int64(len(stdout) + len(stderr))
+	if sequence != b.next && b.memory+size > b.limit {
+		err = e.spill()
+		if err != nil {
+			return
+		}
+	} else {
+		b.memory += size
+	}
+	b.pending[sequence] = e
+
+	return b.release()
+}
+
+// Skip mark a sequence number as having no output so later jobs are not held up waiting for it
+func (b *Buffer) Skip(sequence int64) error {
+	return b.Add(sequence, nil, nil)
+}
+
+// Flush write all waiting output in sequence order without waiting for missing jobs
+func (b *Buffer) Flush() (err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.pending) > 0 {
+		for _, found := b.pending[b.next]; !found; _, found = b.pending[b.next] {
+			b.next++
+		}
+		err = b.release()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Pending get the number of jobs waiting for earlier jobs
+func (b *Buffer) Pending() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.pending)
+}
+
+// release write output for as many jobs as are ready
+func (b *Buffer) release() (err error) {
+	for {
+		e, found := b.pending[b.next]
+		if !found {
+			return
+		}
+		delete(b.pending, b.next)
+		b.next++
+		if e.path == "" {
+			b.memory -= int64(len(e.stdout) + len(e.stderr))
+		}
+		err = b.write(e)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write write the output for a job, reading it back first if it was spilled
+func (b *Buffer) write(e *entry) (err error) {
+	if e.path != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(e.path)
+		os.Remove(e.path)
+		if err != nil {
+			return
+		}
+		e.stdout, e.stderr = data[:e.split], data[e.split:]
+	}
+	if len(e.stdout) > 0 {
+		_, err = b.stdout.Write(e.stdout)
+		if err != nil {
+			return
+		}
+	}
+	if len(e.stderr) > 0 {
+		_, err = b.stderr.Write(e.stderr)
+	}
+
+	return
+}
+
+// spill move output to a temporary file
+func (e *entry) spill() (err error) {
+	file, err := ioutil.TempFile("", "concur-*")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(e.stdout)
+	if err == nil {
+		_, err = file.Write(e.stderr)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	e.path = file.Name()
+	e.split = len(e.stdout)
+	e.stdout, e.stderr = nil, nil
+
+	return
+}
@@ -0,0 +1,62 @@
+package reorder
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestBuffer(t *testing.T) {
+	is := is.New(t)
+
+	var stdout, stderr bytes.Buffer
+	b := New(1, 1024, &stdout, &stderr)
+
+	is.NoErr(b.Add(3, []byte("3\n"), nil))
+	is.NoErr(b.Add(2, []byte("2\n"), []byte("error 2\n")))
+	is.Equal(stdout.String(), "")
+	is.Equal(b.Pending(), 2)
+
+	is.NoErr(b.Add(1, []byte("1\n"), nil))
+	is.Equal(stdout.String(), "1\n2\n3\n")
+	is.Equal(stderr.String(), "error 2\n")
+	is.Equal(b.Pending(), 0)
+
+	// A skipped job does not hold up later jobs
+	is.NoErr(b.Add(5, []byte("5\n"), nil))
+	is.NoErr(b.Skip(4))
+	is.Equal(stdout.String(), "1\n2\n3\n5\n")
+}
+
+func TestBufferSpill(t *testing.T) {
+	is := is.New(t)
+
+	var stdout, stderr bytes.Buffer
+	// A small limit forces waiting output into temporary files
+	b := New(1, 8, &stdout, &stderr)
+
+	var expected string
+	for i := 10; i > 1; i-- {
+		is.NoErr(b.Add(int64(i), []byte(fmt.Sprintf("output %d\n", i)), []byte(fmt.Sprintf("e%d\n", i))))
+	}
+	for i := 1; i <= 10; i++ {
+		expected += fmt.Sprintf("output %d\n", i)
+	}
+	is.NoErr(b.Add(1, []byte("output 1\n"), nil))
+	is.Equal(stdout.String(), expected)
+	is.Equal(b.Pending(), 0)
+}
+
+func TestBufferFlush(t *testing.T) {
+	is := is.New(t)
+
+	var stdout, stderr bytes.Buffer
+	b := New(1, 1024, &stdout, &stderr)
+
+	is.NoErr(b.Add(4, []byte("4\n"), nil))
+	is.NoErr(b.Add(2, []byte("2\n"), nil))
+	is.NoErr(b.Flush())
+	is.Equal(stdout.String(), "2\n4\n")
+}
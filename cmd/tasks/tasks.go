@@ -1,15 +1,20 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 // Task a task to run
+// A task made from several inputs, such as when inputs are batched, has each input in Items and all of them joined by
+// spaces in Task.
 type Task struct {
-	Task string
+	Task  string
+	Items []string
 }
 
 // NewTask make a new task
@@ -48,10 +53,25 @@ func (tl TaskList) Shuffle() {
 	rand.Shuffle(len(tl.Tasks), func(i, j int) { tl.Tasks[i], tl.Tasks[j] = tl.Tasks[j], tl.Tasks[i] })
 }
 
+// Ways of combining the items of task lists into sets of tasks
+const (
+	CombineZip         = "zip"          // the n'th item of each list, going back to the start of shorter lists
+	CombineProduct     = "product"      // every combination of items, with the last list changing fastest
+	CombineZipShortest = "zip-shortest" // the n'th item of each list until the shortest list runs out
+	CombinePad         = "pad"          // the n'th item of each list with empty items once a list runs out
+)
+
+// ErrExhausted no more sets of tasks can be made from the task lists
+var ErrExhausted = errors.New("task lists exhausted")
+
 // TaskListSet a set of task lists
+// An optional stream supplies items as they become available, such as lines read from stdin. Each streamed item is used
+// as the first task of a set, followed by the next item of each task list.
 type TaskListSet struct {
 	TaskLists []*TaskList
 	Offset    int64
+	Stream    <-chan string
+	Combine   string // how the lists are combined, zip if not set
 }
 
 // NewTaskListSet make a new task list set
@@ -72,11 +92,88 @@ func (tls *TaskListSet) OffsetReset() {
 	atomic.StoreInt64(&tls.Offset, 0)
 }
 
+// SetStream set the stream of items to use as the first task of each set
+func (tls *TaskListSet) SetStream(stream <-chan string) {
+	tls.Stream = stream
+}
+
 // AddTaskList add a task list ot the taskSet
 func (tls *TaskListSet) AddTaskList(taskList TaskList) {
 	tls.TaskLists = append(tls.TaskLists, &taskList)
 }
 
+// SetCombine set how the items of the lists are combined into sets
+func (tls *TaskListSet) SetCombine(mode string) (err error) {
+	switch mode {
+	case "", CombineZip, CombineProduct, CombineZipShortest, CombinePad:
+		tls.Combine = mode
+	default:
+		err = fmt.Errorf(
+			"unknown combine mode %s, use %s, %s, %s or %s",
+			mode, CombineZip, CombineProduct, CombineZipShortest, CombinePad,
+		)
+	}
+
+	return
+}
+
+// Count get the number of sets of tasks the lists make
+func (tls *TaskListSet) Count() (count int) {
+	if len(tls.TaskLists) == 0 {
+		return 0
+	}
+	switch tls.Combine {
+	case CombineProduct:
+		count = 1
+		for _, v := range tls.TaskLists {
+			count *= len(v.Tasks)
+		}
+	case CombineZipShortest:
+		count = tls.Min()
+	default:
+		count = tls.Max()
+	}
+
+	return
+}
+
+// Set get the set of tasks at an index, combining the lists according to the combine mode
+func (tls *TaskListSet) Set(index int) (tasks []Task) {
+	tasks = make([]Task, len(tls.TaskLists))
+	// For a product the index is a number with a digit for each list
+	remaining := index
+	for i := len(tls.TaskLists) - 1; i >= 0; i-- {
+		list := tls.TaskLists[i].Tasks
+		if len(list) == 0 {
+			continue
+		}
+		switch tls.Combine {
+		case CombineProduct:
+			tasks[i] = list[remaining%len(list)]
+			remaining /= len(list)
+		case CombinePad:
+			if index < len(list) {
+				tasks[i] = list[index]
+			}
+		default:
+			tasks[i] = list[index%len(list)]
+		}
+	}
+
+	return
+}
+
+// Min get minimum task list size
+func (tls *TaskListSet) Min() (min int) {
+	for i, v := range tls.TaskLists {
+		if i == 0 || len(v.Tasks) < min {
+			min = len(v.Tasks)
+		}
+	}
+
+	return
+}
+
 // Max get maximum task list size
 func (tls *TaskListSet) Max() (max int) {
 	for _, v := range tls.TaskLists {
@@ -89,14 +186,41 @@ func (tls *TaskListSet) Max() (max int) {
 }
 
 // NextAll get next item slice for all tasks item lists
-func (tls TaskListSet) NextAll() (tasks []Task, err error) {
-	for i := range tls.TaskLists {
-		var task Task
-		task, err = tls.Next(i)
-		if err != nil {
+// With zip and pad there is always a next set. With a product or zip-shortest ErrExhausted is returned once every set
+// has been used.
+func (tls *TaskListSet) NextAll() (tasks []Task, err error) {
+	if len(tls.TaskLists) == 0 {
+		return
+	}
+	offset := int(atomic.AddInt64(&tls.Offset, 1) - 1)
+	switch tls.Combine {
+	case CombineProduct, CombineZipShortest:
+		if offset >= tls.Count() {
+			err = ErrExhausted
 			return
 		}
-		tasks = append(tasks, task)
+	}
+	tasks = tls.Set(offset)
+
+	return
+}
+
+// Merge combine several task sets into one with a task for each list holding the items from every set
+func Merge(taskSets [][]Task) (tasks []Task) {
+	for _, taskSet := range taskSets {
+		for i, task := range taskSet {
+			if i == len(tasks) {
+				tasks = append(tasks, Task{})
+			}
+			if task.Items != nil {
+				tasks[i].Items = append(tasks[i].Items, task.Items...)
+			} else {
+				tasks[i].Items = append(tasks[i].Items, task.Task)
+			}
+		}
+	}
+	for i := range tasks {
+		tasks[i].Task = strings.Join(tasks[i].Items, " ")
 	}
 
 	return
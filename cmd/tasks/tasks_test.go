@@ -52,3 +52,57 @@ func TestTaskSet(t *testing.T) {
 // 	}
 // 	is.True(1 == 1)
 // }
+
+func TestMerge(t *testing.T) {
+	is := is.New(t)
+
+	merged := Merge([][]Task{
+		{{Task: "a"}, {Task: "1"}},
+		{{Task: "b c"}, {Task: "2"}},
+	})
+	is.Equal(len(merged), 2)
+	is.Equal(merged[0].Items, []string{"a", "b c"})
+	is.Equal(merged[0].Task, "a b c")
+	is.Equal(merged[1].Items, []string{"1", "2"})
+}
+
+func TestCombine(t *testing.T) {
+	is := is.New(t)
+
+	newTaskListSet := func(mode string) TaskListSet {
+		taskListSet := NewTaskListSet()
+		for _, items := range [][]string{{"a", "b"}, {"1", "2", "3"}} {
+			taskList := NewTaskList()
+			taskList.Add(items...)
+			taskListSet.AddTaskList(taskList)
+		}
+		is.NoErr(taskListSet.SetCombine(mode))
+
+		return taskListSet
+	}
+	sets := func(taskListSet TaskListSet) (all []string) {
+		for {
+			taskSet, err := taskListSet.NextAll()
+			if err == ErrExhausted || len(all) == taskListSet.Count() {
+				return
+			}
+			all = append(all, taskSet[0].Task+taskSet[1].Task)
+		}
+	}
+
+	is.Equal(sets(newTaskListSet(CombineZip)), []string{"a1", "b2", "a3"})
+	is.Equal(sets(newTaskListSet(CombineProduct)), []string{"a1", "a2", "a3", "b1", "b2", "b3"})
+	is.Equal(sets(newTaskListSet(CombineZipShortest)), []string{"a1", "b2"})
+	is.Equal(sets(newTaskListSet(CombinePad)), []string{"a1", "b2", "3"})
+
+	// A product runs out
+	taskListSet := newTaskListSet(CombineProduct)
+	for i := 0; i < 6; i++ {
+		_, err := taskListSet.NextAll()
+		is.NoErr(err)
+	}
+	_, err := taskListSet.NextAll()
+	is.Equal(err, ErrExhausted)
+
+	is.True(taskListSet.SetCombine("bad") != nil)
+}